@@ -20,11 +20,28 @@ const (
 
 func main() {
 	args := os.Args[1:]
-	if len(args) < 2 || (args[0] != "data" && args[0] != "shape" && args[0] != "versions") {
-		fmt.Fprintln(os.Stderr, "Usage: iaviewer <data|shape|versions> <leveldb dir> [version number]")
+	if len(args) < 2 || (args[0] != "data" && args[0] != "shape" && args[0] != "versions" && args[0] != "verify") {
+		fmt.Fprintln(os.Stderr, "Usage: iaviewer <data|shape|versions|verify> <leveldb dir> [version number]")
 		os.Exit(1)
 	}
 
+	if args[0] == "verify" {
+		db, err := OpenDb(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening DB: %s\n", err)
+			os.Exit(1)
+		}
+		ok, err := PrintVerify(db)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error verifying tree: %s\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(2)
+		}
+		return
+	}
+
 	version := 0
 	if len(args) == 3 {
 		var err error
@@ -89,14 +106,29 @@ func PrintDbStats(db dbm.DB) {
 	}
 }
 
-// ReadTree loads an iavl tree from the directory
+// ReadTree loads an iavl tree from the directory, with the hasher recorded
+// in its metadata.
 // If version is 0, load latest, otherwise, load named version
 func ReadTree(dir string, version int) (*iavl.MutableTree, error) {
 	db, err := OpenDb(dir)
 	if err != nil {
 		return nil, err
 	}
-	tree := iavl.NewMutableTree(db, DefaultCacheSize)
+	opts := iavl.DefaultOptions()
+	meta, err := iavl.ReadMetadata(db)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		fmt.Printf("Metadata: node encoding %d, key format %d, hasher %s, release %q\n",
+			meta.NodeEncoding, meta.KeyFormat, meta.Hasher, meta.Release)
+		hasher, ok := iavl.GetHasher(meta.Hasher)
+		if !ok {
+			return nil, fmt.Errorf("unknown hasher %q", meta.Hasher)
+		}
+		opts.Hasher = hasher
+	}
+	tree := iavl.NewMutableTreeWithOpts(db, DefaultCacheSize, opts)
 	ver, err := tree.LoadVersion(int64(version))
 	fmt.Printf("Got version: %d\n", ver)
 	return tree, err
@@ -158,3 +190,20 @@ func PrintVersions(tree *iavl.MutableTree) {
 		fmt.Printf("  %d\n", v)
 	}
 }
+
+// PrintVerify checks the integrity of the tree in the database, prints the
+// problems found, and returns whether there were none.
+func PrintVerify(db dbm.DB) (bool, error) {
+	report, err := iavl.Verify(db)
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Checked %d versions, %d nodes and %d orphans\n", report.Versions, report.Nodes, report.Orphans)
+	for _, problem := range report.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+	if !report.OK() {
+		fmt.Printf("Found %d problems\n", len(report.Problems))
+	}
+	return report.OK(), nil
+}
@@ -0,0 +1,165 @@
+package iavl
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	dbm "github.com/tendermint/tm-db"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// crashDB is a fault-injection harness. It counts writes, where a batch is a
+// single atomic write, and panics with errCrash instead of performing the
+// crashAt'th write, as if the process crashed.
+type crashDB struct {
+	dbm.DB
+	writes  int
+	crashAt int // Never crashes if 0.
+}
+
+func (db *crashDB) write() {
+	db.writes++
+	if db.crashAt > 0 && db.writes >= db.crashAt {
+		panic(errCrash)
+	}
+}
+
+func (db *crashDB) Set(key, value []byte)     { db.write(); db.DB.Set(key, value) }
+func (db *crashDB) SetSync(key, value []byte) { db.write(); db.DB.SetSync(key, value) }
+func (db *crashDB) Delete(key []byte)         { db.write(); db.DB.Delete(key) }
+func (db *crashDB) DeleteSync(key []byte)     { db.write(); db.DB.DeleteSync(key) }
+
+func (db *crashDB) NewBatch() dbm.Batch {
+	return &crashBatch{Batch: db.DB.NewBatch(), db: db}
+}
+
+type crashBatch struct {
+	dbm.Batch
+	db *crashDB
+}
+
+func (b *crashBatch) Write()     { b.db.write(); b.Batch.Write() }
+func (b *crashBatch) WriteSync() { b.db.write(); b.Batch.WriteSync() }
+
+// runCrashing runs the scenario on memDB, crashing at the crashAt'th write,
+// and returns whether it crashed.
+func runCrashing(t *testing.T, memDB dbm.DB, crashAt int, scenario func(db dbm.DB)) (crashed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			require.Equal(t, errCrash, r)
+			crashed = true
+		}
+	}()
+	scenario(&crashDB{DB: memDB, crashAt: crashAt})
+	return false
+}
+
+func crashScenario(t *testing.T, opts *Options, record func(version int64, hash []byte)) func(db dbm.DB) {
+	return func(db dbm.DB) {
+		tree := NewMutableTreeWithOpts(db, 0, opts)
+		_, err := tree.Load()
+		require.NoError(t, err)
+		save := func() {
+			hash, version, err := tree.SaveVersion()
+			require.NoError(t, err)
+			record(version, hash)
+		}
+		for v := 0; v < 6; v++ {
+			for i := 0; i < 10; i++ {
+				tree.Set([]byte(fmt.Sprintf("k%02d", (v*7+i*3)%40)), []byte(fmt.Sprintf("v%d", v)))
+			}
+			tree.Remove([]byte(fmt.Sprintf("k%02d", (v*11)%40)))
+			save()
+		}
+		require.NoError(t, tree.DeleteVersion(4))
+		_, err = tree.LoadVersionForOverwriting(5)
+		require.NoError(t, err)
+		for v := 6; v < 8; v++ {
+			tree.Set([]byte(fmt.Sprintf("k%02d", v)), []byte("overwritten"))
+			save()
+		}
+	}
+}
+
+func TestCrashConsistency(t *testing.T) {
+	opts := &Options{FastIndex: true, Pruning: NewPruningOptions(3, 0, 0)}
+
+	// A run without crashes records the hashes each version may have.
+	hashes := map[int64]map[string]bool{}
+	record := func(version int64, hash []byte) {
+		if hashes[version] == nil {
+			hashes[version] = map[string]bool{}
+		}
+		hashes[version][string(hash)] = true
+	}
+	require.False(t, runCrashing(t, dbm.NewMemDB(), 0, crashScenario(t, opts, record)))
+
+	noop := func(int64, []byte) {}
+	for crashAt := 1; ; crashAt++ {
+		memDB := dbm.NewMemDB()
+		if !runCrashing(t, memDB, crashAt, crashScenario(t, opts, noop)) {
+			break
+		}
+
+		report := mustVerify(t, memDB)
+		require.True(t, report.OK(), "crash at write %d: %v", crashAt, report.Problems)
+
+		tree := NewMutableTreeWithOpts(memDB, 0, opts)
+		_, err := tree.Load()
+		require.NoError(t, err)
+		for _, version := range tree.AvailableVersions() {
+			itree, err := tree.GetImmutable(int64(version))
+			require.NoError(t, err)
+			require.True(t, hashes[int64(version)][string(itree.Hash())],
+				"crash at write %d: unexpected hash of version %d", crashAt, version)
+		}
+		plain := NewMutableTree(memDB, 0)
+		_, err = plain.Load()
+		require.NoError(t, err)
+		requireSameReads(t, plain.ImmutableTree, tree.ImmutableTree)
+	}
+}
+
+func TestCrashConsistencyImport(t *testing.T) {
+	source := NewMutableTree(dbm.NewMemDB(), 0)
+	for i := 0; i < 6000; i++ {
+		source.Set([]byte(fmt.Sprintf("k%05d", i)), []byte{byte(i)})
+	}
+	_, _, err := source.SaveVersion()
+	require.NoError(t, err)
+	nodes := exportAll(t, source.ImmutableTree)
+	require.True(t, len(nodes) > importBatchSize)
+
+	importAll := func(db dbm.DB) {
+		tree := NewMutableTree(db, 0)
+		_, err := tree.Load()
+		require.NoError(t, err)
+		importer, err := tree.Import(source.Version(), source.Hash())
+		require.NoError(t, err)
+		for _, node := range nodes {
+			require.NoError(t, importer.Add(node))
+		}
+		require.NoError(t, importer.Commit())
+	}
+
+	for crashAt := 1; ; crashAt++ {
+		memDB := dbm.NewMemDB()
+		if !runCrashing(t, memDB, crashAt, importAll) {
+			break
+		}
+
+		// Loading rolls back the interrupted import, which can then be retried.
+		tree := NewMutableTree(memDB, 0)
+		version, err := tree.Load()
+		require.NoError(t, err)
+		require.Zero(t, version)
+		require.Empty(t, tree.ndb.nodes())
+		require.True(t, mustVerify(t, memDB).OK())
+
+		importAll(memDB)
+		require.True(t, mustVerify(t, memDB).OK())
+	}
+}
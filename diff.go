@@ -0,0 +1,140 @@
+package iavl
+
+import (
+	"bytes"
+)
+
+// ChangeType is the kind of change made to a key.
+type ChangeType int
+
+const (
+	// ChangeAdd is a key that was added.
+	ChangeAdd ChangeType = iota + 1
+	// ChangeUpdate is a key whose value was changed.
+	ChangeUpdate
+	// ChangeRemove is a key that was removed.
+	ChangeRemove
+)
+
+// String implements fmt.Stringer.
+func (c ChangeType) String() string {
+	switch c {
+	case ChangeAdd:
+		return "add"
+	case ChangeUpdate:
+		return "update"
+	case ChangeRemove:
+		return "remove"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a change made to a key. OldValue is nil for added keys, and
+// NewValue is nil for removed keys.
+type Change struct {
+	Type     ChangeType
+	Key      []byte
+	OldValue []byte
+	NewValue []byte
+}
+
+// Diff returns the changes between fromVersion and toVersion, ordered by
+// key. Subtrees that are shared by both versions are skipped, so the cost is
+// proportional to the number of changes rather than the size of the tree.
+func (tree *MutableTree) Diff(fromVersion, toVersion int64) ([]*Change, error) {
+	from, err := tree.GetImmutable(fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	to, err := tree.GetImmutable(toVersion)
+	if err != nil {
+		return nil, err
+	}
+	return diffTrees(from, to, nil), nil
+}
+
+// diffTrees returns the changes between two trees, ordered by key. If shared
+// is not nil, it is called with each subtree that both trees share.
+func diffTrees(from, to *ImmutableTree, shared func(node *Node)) []*Change {
+	changes := []*Change{}
+	diffLeaves(from, to, shared, func(fromLeaf, toLeaf *Node) {
+		switch {
+		case toLeaf == nil:
+			changes = append(changes, &Change{Type: ChangeRemove, Key: fromLeaf.key, OldValue: fromLeaf.value})
+		case fromLeaf == nil:
+			changes = append(changes, &Change{Type: ChangeAdd, Key: toLeaf.key, NewValue: toLeaf.value})
+		case !bytes.Equal(fromLeaf.value, toLeaf.value):
+			changes = append(changes, &Change{
+				Type:     ChangeUpdate,
+				Key:      fromLeaf.key,
+				OldValue: fromLeaf.value,
+				NewValue: toLeaf.value,
+			})
+		}
+	})
+	return changes
+}
+
+// diffLeaves walks both trees in key order. Each stack holds the subtrees of
+// its tree that remain to be walked, with the next one on top. When the tops
+// of both stacks have the same hash they hold the same keys, and are skipped
+// together. Otherwise the taller one is split into its children, until both
+// are leaves that can be compared. If shared is not nil, it is called with
+// each subtree that is skipped. leaves is called in key order with the leaves
+// that are not shared, paired by key, with nil for a key missing from a tree.
+// Leaves with the same key may still have the same value.
+func diffLeaves(from, to *ImmutableTree, shared func(node *Node), leaves func(fromLeaf, toLeaf *Node)) {
+	fromStack, toStack := []*Node{}, []*Node{}
+	if from.root != nil {
+		fromStack = append(fromStack, from.root)
+	}
+	if to.root != nil {
+		toStack = append(toStack, to.root)
+	}
+
+	split := func(t *ImmutableTree, stack []*Node) []*Node {
+		node := stack[len(stack)-1]
+		return append(stack[:len(stack)-1], node.getRightNode(t), node.getLeftNode(t))
+	}
+
+	for {
+		var fromNode, toNode *Node
+		if len(fromStack) > 0 {
+			fromNode = fromStack[len(fromStack)-1]
+		}
+		if len(toStack) > 0 {
+			toNode = toStack[len(toStack)-1]
+		}
+
+		switch {
+		case fromNode == nil && toNode == nil:
+			return
+
+		case fromNode != nil && toNode != nil && fromNode.hash != nil && bytes.Equal(fromNode.hash, toNode.hash):
+			if shared != nil {
+				shared(fromNode)
+			}
+			fromStack, toStack = fromStack[:len(fromStack)-1], toStack[:len(toStack)-1]
+
+		case fromNode != nil && !fromNode.isLeaf() && (toNode == nil || fromNode.height >= toNode.height):
+			fromStack = split(from, fromStack)
+
+		case toNode != nil && !toNode.isLeaf():
+			toStack = split(to, toStack)
+
+		// Both are leaves, or one of the trees has no keys left.
+		case toNode == nil || (fromNode != nil && bytes.Compare(fromNode.key, toNode.key) < 0):
+			leaves(fromNode, nil)
+			fromStack = fromStack[:len(fromStack)-1]
+
+		case fromNode == nil || bytes.Compare(fromNode.key, toNode.key) > 0:
+			leaves(nil, toNode)
+			toStack = toStack[:len(toStack)-1]
+
+		default:
+			leaves(fromNode, toNode)
+			fromStack, toStack = fromStack[:len(fromStack)-1], toStack[:len(toStack)-1]
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package iavl
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+// naiveDiff computes the changes between two trees by comparing all keys.
+func naiveDiff(from, to *ImmutableTree) []*Change {
+	changes := []*Change{}
+	from.Iterate(func(key, value []byte) bool {
+		if newValue := to.Get(key); newValue == nil {
+			changes = append(changes, &Change{Type: ChangeRemove, Key: key, OldValue: value})
+		} else if !bytes.Equal(value, newValue) {
+			changes = append(changes, &Change{Type: ChangeUpdate, Key: key, OldValue: value, NewValue: newValue})
+		}
+		return false
+	})
+	to.Iterate(func(key, value []byte) bool {
+		if !from.Has(key) {
+			changes = append(changes, &Change{Type: ChangeAdd, Key: key, NewValue: value})
+		}
+		return false
+	})
+	sort.Slice(changes, func(i, j int) bool {
+		return bytes.Compare(changes[i].Key, changes[j].Key) < 0
+	})
+	return changes
+}
+
+func TestDiff(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for v := 1; v <= 10; v++ {
+		for i := 0; i < 50; i++ {
+			tree.Set([]byte(cmn.RandStr(2)), []byte(cmn.RandStr(2)))
+		}
+		for i := 0; i < 20; i++ {
+			tree.Remove([]byte(cmn.RandStr(2)))
+		}
+		if v == 5 {
+			// Empty version.
+			keys := [][]byte{}
+			tree.Iterate(func(key, value []byte) bool {
+				keys = append(keys, key)
+				return false
+			})
+			for _, key := range keys {
+				tree.Remove(key)
+			}
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	for from := int64(1); from <= 10; from++ {
+		for to := int64(1); to <= 10; to++ {
+			fromTree, err := tree.GetImmutable(from)
+			require.NoError(t, err)
+			toTree, err := tree.GetImmutable(to)
+			require.NoError(t, err)
+
+			changes, err := tree.Diff(from, to)
+			require.NoError(t, err)
+			require.Equal(t, naiveDiff(fromTree, toTree), changes, "from %v to %v", from, to)
+		}
+	}
+
+	_, err := tree.Diff(1, 11)
+	require.Error(t, err)
+}
+
+// countingDB counts the reads of the wrapped database.
+type countingDB struct {
+	db.DB
+	gets int
+}
+
+func (c *countingDB) Get(key []byte) []byte {
+	c.gets++
+	return c.DB.Get(key)
+}
+
+func TestDiffSkipsSharedSubtrees(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTree(memDB, 0)
+	for i := 0; i < 1000; i++ {
+		tree.Set([]byte(cmn.RandStr(8)), []byte(cmn.RandStr(8)))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	tree.Set([]byte("key"), []byte("value"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+
+	counter := &countingDB{DB: memDB}
+	tree = NewMutableTree(counter, 0)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	counter.gets = 0
+
+	changes, err := tree.Diff(1, 2)
+	require.NoError(t, err)
+	require.Equal(t, []*Change{{Type: ChangeAdd, Key: []byte("key"), NewValue: []byte("value")}}, changes)
+	// Only the paths to the new key differ, with about 2*log2(1000) nodes.
+	require.True(t, counter.gets < 100, "read %v nodes", counter.gets)
+}
+
+func TestDiffSharedSubtrees(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 100; i++ {
+		tree.Set([]byte(cmn.RandStr(8)), []byte(cmn.RandStr(8)))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	tree.Set([]byte("key"), []byte("value"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	from, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	to, err := tree.GetImmutable(2)
+	require.NoError(t, err)
+
+	// The skipped subtrees are in both trees, and hold all the other keys.
+	hashes := func(version *ImmutableTree) map[string]bool {
+		hashes := map[string]bool{}
+		version.root.traverse(version, true, func(node *Node) bool {
+			hashes[string(node.hash)] = true
+			return false
+		})
+		return hashes
+	}
+	fromHashes, toHashes := hashes(from), hashes(to)
+	size := int64(0)
+	changes := diffTrees(from, to, func(node *Node) {
+		require.True(t, fromHashes[string(node.hash)])
+		require.True(t, toHashes[string(node.hash)])
+		size += node.size
+	})
+	require.Len(t, changes, 1)
+	require.True(t, size > 0 && size <= from.Size(), "shared subtrees hold %d keys", size)
+}
@@ -0,0 +1,75 @@
+package iavl
+
+import (
+	"fmt"
+)
+
+// ErrExportDone is returned by Exporter.Next() when all nodes have been exported.
+var ErrExportDone = fmt.Errorf("export is complete")
+
+// ExportNode contains exported node data. Nodes are exported in post-order,
+// so that children always come before their parent.
+type ExportNode struct {
+	Key     []byte `json:"key"`
+	Value   []byte `json:"value"`
+	Version int64  `json:"version"`
+	Height  int8   `json:"height"`
+}
+
+// Exporter exports the nodes of a single ImmutableTree version as a
+// deterministic post-order stream. It does not hold any locks, so the tree
+// must not be modified (e.g. pruned) while it is being exported.
+type Exporter struct {
+	tree  *ImmutableTree
+	stack []exportFrame
+}
+
+type exportFrame struct {
+	node     *Node
+	expanded bool // children have been pushed onto the stack
+}
+
+// Export returns an Exporter for the tree.
+func (t *ImmutableTree) Export() *Exporter {
+	e := &Exporter{tree: t}
+	if t.root != nil {
+		e.stack = append(e.stack, exportFrame{node: t.root})
+	}
+	return e
+}
+
+// Hash returns the root hash of the exported tree, which an Importer must
+// reproduce before it commits.
+func (e *Exporter) Hash() []byte {
+	return e.tree.Hash()
+}
+
+// Version returns the version of the exported tree.
+func (e *Exporter) Version() int64 {
+	return e.tree.version
+}
+
+// Next returns the next exported node, or ErrExportDone when there are no
+// more nodes.
+func (e *Exporter) Next() (*ExportNode, error) {
+	for len(e.stack) > 0 {
+		top := &e.stack[len(e.stack)-1]
+		node := top.node
+		if node.isLeaf() || top.expanded {
+			e.stack = e.stack[:len(e.stack)-1]
+			return &ExportNode{
+				Key:     node.key,
+				Value:   node.value,
+				Version: node.version,
+				Height:  node.height,
+			}, nil
+		}
+		top.expanded = true
+		// Push right first, so that the left subtree is exported first.
+		e.stack = append(e.stack,
+			exportFrame{node: node.getRightNode(e.tree)},
+			exportFrame{node: node.getLeftNode(e.tree)},
+		)
+	}
+	return nil, ErrExportDone
+}
@@ -0,0 +1,204 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func setupExportTreeRandom(t *testing.T) *ImmutableTree {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for v := 1; v <= 5; v++ {
+		for i := 0; i < 200; i++ {
+			tree.Set([]byte(cmn.RandStr(4)), []byte(cmn.RandStr(8)))
+		}
+		for i := 0; i < 50; i++ {
+			tree.Remove([]byte(cmn.RandStr(4)))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	itree, err := tree.GetImmutable(tree.Version())
+	require.NoError(t, err)
+	return itree
+}
+
+func exportAll(t *testing.T, itree *ImmutableTree) []*ExportNode {
+	nodes := []*ExportNode{}
+	exporter := itree.Export()
+	for {
+		node, err := exporter.Next()
+		if err == ErrExportDone {
+			break
+		}
+		require.NoError(t, err)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func TestExporter(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	tree.Set([]byte("a"), []byte{1})
+	tree.Set([]byte("b"), []byte{2})
+	tree.Set([]byte("c"), []byte{3})
+	_, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	itree, err := tree.GetImmutable(version)
+	require.NoError(t, err)
+
+	expect := []*ExportNode{
+		{Key: []byte("a"), Value: []byte{1}, Version: 1, Height: 0},
+		{Key: []byte("b"), Value: []byte{2}, Version: 1, Height: 0},
+		{Key: []byte("c"), Value: []byte{3}, Version: 1, Height: 0},
+		{Key: []byte("c"), Value: nil, Version: 1, Height: 1},
+		{Key: []byte("b"), Value: nil, Version: 1, Height: 2},
+	}
+	require.Equal(t, expect, exportAll(t, itree))
+}
+
+func TestExporterImporter(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	nodes := exportAll(t, itree)
+	require.Equal(t, itree.nodeSize(), len(nodes))
+
+	// Exports must be deterministic.
+	require.Equal(t, nodes, exportAll(t, itree))
+
+	newTree := NewMutableTree(db.NewMemDB(), 0)
+	importer, err := newTree.Import(itree.Version(), itree.Hash())
+	require.NoError(t, err)
+	for _, node := range nodes {
+		require.NoError(t, importer.Add(node))
+	}
+	require.NoError(t, importer.Commit())
+
+	require.Equal(t, itree.Version(), newTree.Version())
+	require.Equal(t, itree.Hash(), newTree.Hash())
+	require.Equal(t, []int{int(itree.Version())}, newTree.AvailableVersions())
+	itree.Iterate(func(key, value []byte) bool {
+		newValue := newTree.Get(key)
+		require.Equal(t, value, newValue)
+		return false
+	})
+
+	// The imported tree can be reloaded and extended.
+	reloaded := NewMutableTree(newTree.ndb.db, 0)
+	version, err := reloaded.Load()
+	require.NoError(t, err)
+	require.Equal(t, itree.Version(), version)
+	reloaded.Set([]byte("new"), []byte("value"))
+	_, version, err = reloaded.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, itree.Version()+1, version)
+}
+
+func TestImporterHashMismatch(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	nodes := exportAll(t, itree)
+
+	newTree := NewMutableTree(db.NewMemDB(), 0)
+	importer, err := newTree.Import(itree.Version(), itree.Hash())
+	require.NoError(t, err)
+	for _, node := range nodes {
+		if node.Height == 0 && len(node.Value) > 0 {
+			node.Value = append([]byte{}, node.Value...)
+			node.Value[0]++
+		}
+		require.NoError(t, importer.Add(node))
+	}
+	require.Error(t, importer.Commit())
+	require.Empty(t, newTree.ndb.roots())
+	require.Equal(t, int64(0), newTree.Version())
+	// The nodes written by the import are deleted, including those that are
+	// still in the batch.
+	newTree.ndb.Commit()
+	require.Empty(t, newTree.ndb.nodes())
+	require.Empty(t, newTree.ndb.getPending())
+}
+
+func TestImporterInvalidNode(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	nodes := exportAll(t, itree)
+
+	newTree := NewMutableTree(db.NewMemDB(), 0)
+	importer, err := newTree.Import(itree.Version(), itree.Hash())
+	require.NoError(t, err)
+	for _, node := range nodes[:len(nodes)/2] {
+		require.NoError(t, importer.Add(node))
+	}
+	// Write the nodes added so far, as a full batch would.
+	newTree.ndb.Commit()
+	require.NotEmpty(t, newTree.ndb.nodes())
+
+	invalid := *nodes[len(nodes)/2]
+	invalid.Version = itree.Version() + 1
+	require.Error(t, importer.Add(&invalid))
+	newTree.ndb.Commit()
+	require.Empty(t, newTree.ndb.nodes())
+	require.Empty(t, newTree.ndb.roots())
+	require.Empty(t, newTree.ndb.getPending())
+
+	// The importer can't be used after a failure.
+	require.Error(t, importer.Add(nodes[len(nodes)/2]))
+	require.Error(t, importer.Commit())
+	newTree.ndb.Commit()
+	require.Empty(t, newTree.ndb.nodes())
+}
+
+func TestImporterRollback(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	nodes := exportAll(t, itree)
+
+	memDB := db.NewMemDB()
+	newTree := NewMutableTree(memDB, 0)
+	importer, err := newTree.Import(itree.Version(), itree.Hash())
+	require.NoError(t, err)
+	for _, node := range nodes[:len(nodes)/2] {
+		require.NoError(t, importer.Add(node))
+	}
+	importer.commitBatch()
+	for _, node := range nodes[len(nodes)/2 : len(nodes)-1] {
+		require.NoError(t, importer.Add(node))
+	}
+	importer.commitBatch()
+
+	// A node that the import did not write is left alone by its rollback.
+	stray := &Node{key: []byte("stray"), value: []byte{1}, version: 1, size: 1}
+	stray._hash(newTree.ndb.hasher)
+	newTree.ndb.SaveNode(stray)
+	newTree.ndb.Commit()
+
+	// The import is interrupted, and rolled back by the next load.
+	newTree = NewMutableTree(memDB, 0)
+	version, err := newTree.Load()
+	require.NoError(t, err)
+	require.Zero(t, version)
+	require.Len(t, newTree.ndb.nodes(), 1)
+	require.Equal(t, stray.hash, newTree.ndb.nodes()[0].hash)
+	require.Empty(t, newTree.ndb.getPending())
+	newTree.ndb.traversePrefix(pendingNodesKeyFormat.Key(), func(k, v []byte) {
+		t.Errorf("pending nodes record %X was not deleted", k)
+	})
+}
+
+func TestImporterNonEmptyTree(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	tree.Set([]byte("a"), []byte{1})
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	_, err = tree.Import(2, nil)
+	require.Error(t, err)
+}
+
+func TestImporterEmptyTree(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	importer, err := tree.Import(3, nil)
+	require.NoError(t, err)
+	require.NoError(t, importer.Commit())
+	require.Equal(t, int64(3), tree.Version())
+	require.Nil(t, tree.Hash())
+}
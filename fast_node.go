@@ -0,0 +1,60 @@
+package iavl
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+
+	amino "github.com/tendermint/go-amino"
+)
+
+// fastNode is an entry of the fast node index, which maps each key of the
+// latest version to its value and the version the value was set at.
+type fastNode struct {
+	key     []byte
+	value   []byte
+	version int64
+}
+
+// makeFastNode constructs a fastNode from its key and encoded bytes.
+func makeFastNode(key, buf []byte) (*fastNode, error) {
+	ver, n, cause := amino.DecodeVarint(buf)
+	if cause != nil {
+		return nil, errors.Wrap(cause, "decoding fastNode.version")
+	}
+	buf = buf[n:]
+
+	val, _, cause := amino.DecodeByteSlice(buf)
+	if cause != nil {
+		return nil, errors.Wrap(cause, "decoding fastNode.value")
+	}
+
+	return &fastNode{
+		key:     key,
+		value:   val,
+		version: ver,
+	}, nil
+}
+
+// Writes the fast node as a serialized byte slice to the supplied io.Writer.
+func (fn *fastNode) writeBytes(w io.Writer) error {
+	cause := amino.EncodeVarint(w, fn.version)
+	if cause != nil {
+		return errors.Wrap(cause, "writing version")
+	}
+	cause = amino.EncodeByteSlice(w, fn.value)
+	if cause != nil {
+		return errors.Wrap(cause, "writing value")
+	}
+	return nil
+}
+
+func (fn *fastNode) bytes() []byte {
+	var buf bytes.Buffer
+	buf.Grow(amino.VarintSize(fn.version) + amino.ByteSliceSize(fn.value))
+	if err := fn.writeBytes(&buf); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
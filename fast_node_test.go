@@ -0,0 +1,264 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+type fastIterEntry struct {
+	key, value []byte
+	version    int64
+}
+
+func collectRange(t *ImmutableTree, start, end []byte, ascending, inclusive bool) []fastIterEntry {
+	entries := []fastIterEntry{}
+	if inclusive {
+		t.IterateRangeInclusive(start, end, ascending, func(key, value []byte, version int64) bool {
+			entries = append(entries, fastIterEntry{key, value, version})
+			return false
+		})
+	} else {
+		t.IterateRange(start, end, ascending, func(key, value []byte) bool {
+			entries = append(entries, fastIterEntry{key: key, value: value})
+			return false
+		})
+	}
+	return entries
+}
+
+func requireSameReads(t *testing.T, expected, actual *ImmutableTree) {
+	expected.Iterate(func(key, value []byte) bool {
+		require.Equal(t, value, actual.Get(key))
+		require.True(t, actual.Has(key))
+		index, _ := expected.GetWithIndex(key)
+		actualIndex, actualValue := actual.GetWithIndex(key)
+		require.Equal(t, index, actualIndex)
+		require.Equal(t, value, actualValue)
+		return false
+	})
+	require.Nil(t, actual.Get([]byte("missing")))
+	index, _ := expected.GetWithIndex([]byte("missing"))
+	actualIndex, actualValue := actual.GetWithIndex([]byte("missing"))
+	require.Equal(t, index, actualIndex)
+	require.Nil(t, actualValue)
+	require.False(t, actual.Has([]byte("missing")))
+	require.Equal(t, expected.String(), actual.String())
+	for _, bounds := range [][2][]byte{{nil, nil}, {[]byte("b"), []byte("m")}, {[]byte("m"), nil}, {nil, []byte("c")}} {
+		for _, ascending := range []bool{true, false} {
+			for _, inclusive := range []bool{true, false} {
+				require.Equal(t,
+					collectRange(expected, bounds[0], bounds[1], ascending, inclusive),
+					collectRange(actual, bounds[0], bounds[1], ascending, inclusive))
+			}
+		}
+	}
+}
+
+func TestFastIndex(t *testing.T) {
+	indexed := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{FastIndex: true})
+	plain := NewMutableTree(db.NewMemDB(), 0)
+
+	for v := 0; v < 10; v++ {
+		for i := 0; i < 20; i++ {
+			key, value := []byte(cmn.RandStr(2)), []byte(cmn.RandStr(4))
+			indexed.Set(key, value)
+			plain.Set(key, value)
+		}
+		key := []byte(cmn.RandStr(2))
+		indexed.Remove(key)
+		plain.Remove(key)
+		// Unsaved changes are not in the index, and must be read from the tree.
+		require.False(t, indexed.useFastIndex())
+		requireSameReads(t, plain.ImmutableTree, indexed.ImmutableTree)
+
+		_, _, err := indexed.SaveVersion()
+		require.NoError(t, err)
+		_, _, err = plain.SaveVersion()
+		require.NoError(t, err)
+		require.True(t, indexed.useFastIndex())
+		requireSameReads(t, plain.ImmutableTree, indexed.ImmutableTree)
+	}
+
+	// Older versions are read from the tree.
+	itree, err := indexed.GetImmutable(5)
+	require.NoError(t, err)
+	require.False(t, itree.useFastIndex())
+	plainTree, err := plain.GetImmutable(5)
+	require.NoError(t, err)
+	requireSameReads(t, plainTree, itree)
+
+	// Rolled back changes never reach the index.
+	indexed.Set([]byte("rollback"), []byte("value"))
+	indexed.Rollback()
+	_, _, err = indexed.SaveVersion()
+	require.NoError(t, err)
+	require.Nil(t, indexed.Get([]byte("rollback")))
+
+	// A copy of a saved version that is held while the index moves on, such
+	// as the last saved one, is read from the tree.
+	held := indexed.ImmutableTree.clone()
+	require.True(t, held.useFastIndex())
+	indexed.Set([]byte("held"), []byte("value"))
+	_, _, err = indexed.SaveVersion()
+	require.NoError(t, err)
+	require.True(t, indexed.useFastIndex())
+	require.False(t, held.useFastIndex())
+	require.Nil(t, held.Get([]byte("held")))
+}
+
+func TestFastIndexServesLatestWithoutNodes(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true})
+	tree.Set([]byte("a"), []byte("1"))
+	tree.Set([]byte("b"), []byte("2"))
+	tree.Set([]byte("c"), []byte("3"))
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Remove all nodes but the root, which is already loaded.
+	tree.ndb.traversePrefix(nodeKeyFormat.Key(), func(k, v []byte) {
+		memDB.Delete(k)
+	})
+	tree.ndb.nodeCache = newDefaultCache(0)
+
+	require.Equal(t, []byte("2"), tree.Get([]byte("b")))
+	keys := []string{}
+	tree.Iterate(func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return false
+	})
+	require.Equal(t, []string{"a", "b", "c"}, keys)
+}
+
+func TestFastIndexMigration(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTree(memDB, 0)
+	for v := 0; v < 5; v++ {
+		for i := 0; i < 20; i++ {
+			tree.Set([]byte(cmn.RandStr(2)), []byte(cmn.RandStr(4)))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	indexed := NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true})
+	_, err := indexed.Load()
+	require.NoError(t, err)
+	require.True(t, indexed.useFastIndex())
+	requireSameReads(t, tree.ImmutableTree, indexed.ImmutableTree)
+
+	// Versions saved without the index are caught up with on the next save.
+	tree.Set([]byte("new"), []byte("value"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	indexed = NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true})
+	_, err = indexed.Load()
+	require.NoError(t, err)
+	requireSameReads(t, tree.ImmutableTree, indexed.ImmutableTree)
+	require.Equal(t, []byte("value"), indexed.Get([]byte("new")))
+
+	// Loading an older version for overwriting reindexes it.
+	_, err = indexed.LoadVersionForOverwriting(3)
+	require.NoError(t, err)
+	require.True(t, indexed.useFastIndex())
+	require.Nil(t, indexed.Get([]byte("new")))
+	indexed.Set([]byte("other"), []byte("value"))
+	_, _, err = indexed.SaveVersion()
+	require.NoError(t, err)
+	require.True(t, indexed.useFastIndex())
+	require.Equal(t, []byte("value"), indexed.Get([]byte("other")))
+}
+
+func TestFastIndexLoadOlderVersion(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true})
+	plain := NewMutableTree(db.NewMemDB(), 0)
+	for v := 1; v <= 10; v++ {
+		for _, tr := range []*MutableTree{tree, plain} {
+			for i := 0; i < 20; i++ {
+				tr.Set([]byte{byte(v*7 + i)}, []byte{byte(v)})
+			}
+			tr.Remove([]byte{byte(v * 3)})
+			_, _, err := tr.SaveVersion()
+			require.NoError(t, err)
+		}
+	}
+
+	// Older versions are read from the tree, and leave the index alone.
+	logger := &recordingLogger{}
+	tree = NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true, Logger: logger})
+	_, err := tree.LoadVersion(5)
+	require.NoError(t, err)
+	require.False(t, tree.useFastIndex())
+	version, _ := tree.ndb.getFastIndexState()
+	require.Equal(t, int64(10), version)
+	plainTree, err := plain.GetImmutable(5)
+	require.NoError(t, err)
+	requireSameReads(t, plainTree, tree.ImmutableTree)
+	_, err = tree.Load()
+	require.NoError(t, err)
+	require.True(t, tree.useFastIndex())
+	require.Empty(t, logger.find("info", "updating fast index"))
+	require.Empty(t, logger.find("info", "rebuilding fast index"))
+
+	// Overwriting moves the index back by the keys that changed.
+	_, err = tree.LoadVersionForOverwriting(5)
+	require.NoError(t, err)
+	require.True(t, tree.useFastIndex())
+	requireSameReads(t, plainTree, tree.ImmutableTree)
+	require.Len(t, logger.find("info", "updating fast index"), 1)
+	require.Empty(t, logger.find("info", "rebuilding fast index"))
+}
+
+func TestFastIndexKeepInMemory(t *testing.T) {
+	memDB := db.NewMemDB()
+	opts := &Options{FastIndex: true, KeepInMemory: true, FlushInterval: 3, Pruning: NewPruningOptions(2, 0, 0)}
+	tree := NewMutableTreeWithOpts(memDB, 0, opts)
+	plain := NewMutableTree(db.NewMemDB(), 0)
+	for v := 1; v <= 7; v++ {
+		for _, tr := range []*MutableTree{tree, plain} {
+			tr.Set([]byte{byte(v % 3)}, []byte{byte(v)})
+			tr.Remove([]byte{byte((v + 1) % 3)})
+			_, _, err := tr.SaveVersion()
+			require.NoError(t, err)
+		}
+		requireSameReads(t, plain.ImmutableTree, tree.ImmutableTree)
+		require.Equal(t, v%3 == 0, tree.useFastIndex())
+	}
+
+	reloaded := NewMutableTreeWithOpts(memDB, 0, opts)
+	_, err := reloaded.Load()
+	require.NoError(t, err)
+	require.True(t, reloaded.useFastIndex())
+	expected, err := plain.GetImmutable(6)
+	require.NoError(t, err)
+	requireSameReads(t, expected, reloaded.ImmutableTree)
+}
+
+func TestFastIndexGetReadsNoNodes(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true})
+	for i := 0; i < 100; i++ {
+		tree.Set(indexKey(i), []byte{byte(i)})
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	metrics := &countingMetrics{}
+	tree = NewMutableTreeWithOpts(memDB, 0, &Options{FastIndex: true, Metrics: metrics})
+	_, err = tree.Load()
+	require.NoError(t, err)
+	itree := tree.ImmutableTree
+	require.True(t, itree.useFastIndex())
+
+	// Get reads no tree nodes, whether the key exists or not.
+	metrics.reads = 0
+	for i := 0; i < 100; i++ {
+		require.Equal(t, []byte{byte(i)}, itree.Get(indexKey(i)))
+	}
+	require.Nil(t, itree.Get(indexKey(100)))
+	require.Zero(t, metrics.reads)
+}
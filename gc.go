@@ -0,0 +1,107 @@
+package iavl
+
+import (
+	"github.com/pkg/errors"
+)
+
+// gcBatchSize is the number of nodes deleted per batch by garbage collection.
+const gcBatchSize = 10000
+
+// GCResult is the result of garbage collection.
+type GCResult struct {
+	Nodes int   // Number of unreachable nodes deleted, or found in a dry run.
+	Bytes int64 // Size of their keys and values in the database.
+}
+
+// CollectGarbage deletes the nodes on disk that are not reachable from any
+// saved version and not covered by an orphan entry, such as those left by
+// crashes or old bugs. In a dry run, nothing is deleted, and the result is
+// what would be reclaimed. Versions kept in memory must be flushed first.
+func (tree *MutableTree) CollectGarbage(dryRun bool) (GCResult, error) {
+	if len(tree.memVersions) > 0 {
+		return GCResult{}, errors.New("versions kept in memory must be flushed before collecting garbage")
+	}
+	return tree.ndb.collectGarbage(dryRun)
+}
+
+// collectGarbage marks the nodes reachable from all saved roots, and deletes
+// all other nodes that have no orphan entry in batches. Marking fails if a
+// reachable node is missing or corrupt, since the nodes below it could not
+// be marked.
+func (ndb *nodeDB) collectGarbage(dryRun bool) (GCResult, error) {
+	if err := ndb.checkMetadata(); err != nil {
+		return GCResult{}, err
+	}
+	marked, err := ndb.markReachable()
+	if err != nil {
+		return GCResult{}, err
+	}
+	ndb.traverseOrphans(func(key, hash []byte) {
+		marked[string(hash)] = struct{}{}
+	})
+
+	result := GCResult{}
+	garbage := [][]byte{}
+	ndb.traversePrefix(nodeKeyFormat.Key(), func(key, value []byte) {
+		var hash []byte
+		nodeKeyFormat.Scan(key, &hash)
+		if _, ok := marked[string(hash)]; !ok {
+			garbage = append(garbage, hash)
+			result.Nodes++
+			result.Bytes += int64(len(key) + len(value))
+		}
+	})
+	ndb.logger.Info("collected garbage", "nodes", result.Nodes, "bytes", result.Bytes, "dry_run", dryRun)
+	if dryRun {
+		return result, nil
+	}
+
+	// Use separate batches, so that uncommitted writes are not committed.
+	for len(garbage) > 0 {
+		n := gcBatchSize
+		if n > len(garbage) {
+			n = len(garbage)
+		}
+		batch := ndb.db.NewBatch()
+		for _, hash := range garbage[:n] {
+			batch.Delete(ndb.nodeKey(hash))
+			ndb.nodeCache.Remove(hash)
+		}
+		batch.Write()
+		batch.Close()
+		garbage = garbage[n:]
+	}
+	return result, nil
+}
+
+// markReachable returns the hashes of the nodes reachable from all saved
+// roots.
+func (ndb *nodeDB) markReachable() (map[string]struct{}, error) {
+	roots, err := ndb.getRoots()
+	if err != nil {
+		return nil, err
+	}
+	marked := map[string]struct{}{}
+	for version, root := range roots {
+		stack := [][]byte{}
+		if len(root) > 0 {
+			stack = append(stack, root)
+		}
+		for len(stack) > 0 {
+			hash := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := marked[string(hash)]; ok {
+				continue
+			}
+			node, problem := ndb.verifyNode(hash)
+			if node == nil {
+				return nil, errors.Errorf("marking version %d: %v", version, problem)
+			}
+			marked[string(hash)] = struct{}{}
+			if !node.isLeaf() {
+				stack = append(stack, node.rightHash, node.leftHash)
+			}
+		}
+	}
+	return marked, nil
+}
@@ -0,0 +1,63 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectGarbage(t *testing.T) {
+	tree, memDB := newVerifyTestTree(t)
+	result, err := tree.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Equal(t, GCResult{}, result)
+
+	// Leak a node by dropping the orphan entries of a version before
+	// deleting it, as the orphan-collection bug did.
+	versions := tree.AvailableVersions()
+	orphaned := 0
+	tree.ndb.traverseOrphansRange(int64(versions[0]), int64(versions[0])+1, func(key, hash []byte) {
+		memDB.Delete(key)
+		orphaned++
+	})
+	require.NotZero(t, orphaned)
+	require.NoError(t, tree.DeleteVersion(int64(versions[0])))
+
+	stray := NewNode([]byte("stray"), []byte("value"), 3)
+	stray._hash(SHA256)
+	strayBytes := encodeNode(t, stray)
+	memDB.Set(tree.ndb.nodeKey(stray.hash), strayBytes)
+
+	nodes := len(tree.ndb.nodes())
+	report := mustVerify(t, memDB)
+	require.Len(t, report.Problems, orphaned+1)
+
+	result, err = tree.CollectGarbage(true)
+	require.NoError(t, err)
+	require.Equal(t, orphaned+1, result.Nodes)
+	require.True(t, result.Bytes > int64(len(tree.ndb.nodeKey(stray.hash))+len(strayBytes)))
+	require.Len(t, tree.ndb.nodes(), nodes)
+
+	dryRun := result
+	result, err = tree.CollectGarbage(false)
+	require.NoError(t, err)
+	require.Equal(t, dryRun, result)
+	require.Len(t, tree.ndb.nodes(), nodes-result.Nodes)
+	require.True(t, mustVerify(t, memDB).OK())
+
+	for _, version := range tree.AvailableVersions() {
+		_, err := tree.LazyLoadVersion(int64(version))
+		require.NoError(t, err)
+		tree.Iterate(func(key, value []byte) bool { return false })
+	}
+}
+
+func TestCollectGarbageMissingNode(t *testing.T) {
+	tree, memDB := newVerifyTestTree(t)
+	memDB.Delete(tree.ndb.nodeKey(tree.root.leftHash))
+	nodes := len(tree.ndb.nodes())
+
+	_, err := tree.CollectGarbage(false)
+	require.Error(t, err)
+	require.Len(t, tree.ndb.nodes(), nodes)
+}
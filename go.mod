@@ -4,7 +4,10 @@ go 1.12
 
 require (
 	github.com/coinexchain/codon v0.0.0-20191012070227-3ee72dde596c
+	github.com/confio/ics23/go v0.0.0-20200817220745-f173e6211efb
+	github.com/go-kit/kit v0.9.0
 	github.com/pkg/errors v0.8.1
+	github.com/prometheus/client_golang v0.9.3
 	github.com/stretchr/testify v1.4.0
 	github.com/tendermint/go-amino v0.14.1
 	github.com/tendermint/tendermint v0.32.7
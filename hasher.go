@@ -0,0 +1,99 @@
+package iavl
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sync"
+
+	dbm "github.com/tendermint/tm-db"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher is the hash function of a tree. The name of the hasher is recorded
+// in the tree metadata when the first version is saved, and the tree can then
+// only be loaded with the same hasher. Nodes are keyed by their hash, so
+// hashes must be 32 bytes.
+type Hasher interface {
+	// Name identifies the hash function in the database.
+	Name() string
+	// New returns a new hash.Hash.
+	New() hash.Hash
+}
+
+var (
+	// SHA256 hashes with SHA-256. It is the default, and the hash function of
+	// trees written before the hasher was configurable.
+	SHA256 = NewHasher("sha256", sha256.New)
+
+	// Blake2b256 hashes with BLAKE2b-256.
+	Blake2b256 = NewHasher("blake2b-256", func() hash.Hash {
+		h, err := blake2b.New256(nil)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	})
+)
+
+var (
+	hashersMtx sync.RWMutex
+	hashers    = map[string]Hasher{
+		SHA256.Name():     SHA256,
+		Blake2b256.Name(): Blake2b256,
+	}
+)
+
+type namedHasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+// NewHasher returns a Hasher with the given name and hash function, for
+// example to use BLAKE3. It panics if the hashes are not 32 bytes.
+func NewHasher(name string, newHash func() hash.Hash) Hasher {
+	if size := newHash().Size(); size != hashSize {
+		panic(fmt.Sprintf("hasher %q produces %d byte hashes, must be %d", name, size, hashSize))
+	}
+	return namedHasher{name: name, newHash: newHash}
+}
+
+// Name implements Hasher.
+func (h namedHasher) Name() string {
+	return h.name
+}
+
+// New implements Hasher.
+func (h namedHasher) New() hash.Hash {
+	return h.newHash()
+}
+
+// RegisterHasher registers a hasher by name, so that Verify and tools can
+// open trees written with it. SHA256 and Blake2b256 are always registered.
+func RegisterHasher(hasher Hasher) {
+	hashersMtx.Lock()
+	defer hashersMtx.Unlock()
+	hashers[hasher.Name()] = hasher
+}
+
+// GetHasher returns the registered hasher with the given name.
+func GetHasher(name string) (Hasher, bool) {
+	hashersMtx.RLock()
+	defer hashersMtx.RUnlock()
+	hasher, ok := hashers[name]
+	return hasher, ok
+}
+
+// hashBytes returns the hash of bz.
+func hashBytes(hasher Hasher, bz []byte) []byte {
+	h := hasher.New()
+	h.Write(bz) // nolint:errcheck
+	return h.Sum(nil)
+}
+
+// hasRoots returns whether any version has been saved.
+func (ndb *nodeDB) hasRoots() bool {
+	itr := dbm.IteratePrefix(ndb.db, rootKeyFormat.Key())
+	defer itr.Close()
+	return itr.Valid()
+}
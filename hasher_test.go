@@ -0,0 +1,108 @@
+package iavl
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func newHasherTestTree(t *testing.T, memDB db.DB, hasher Hasher) *MutableTree {
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{Hasher: hasher})
+	_, err := tree.Load()
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		tree.Set([]byte(fmt.Sprintf("k%02d", i*2)), []byte(fmt.Sprintf("v%d", i)))
+	}
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	return tree
+}
+
+func TestHasher(t *testing.T) {
+	sha := newHasherTestTree(t, db.NewMemDB(), nil)
+	blake := newHasherTestTree(t, db.NewMemDB(), Blake2b256)
+	require.NotEqual(t, sha.Hash(), blake.Hash())
+	require.Len(t, blake.Hash(), hashSize)
+
+	// Proofs built by the tree verify with its hasher.
+	value, proof, err := blake.GetWithProof([]byte("k10"))
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify(blake.Hash()))
+	require.NoError(t, proof.VerifyItem([]byte("k10"), value))
+
+	_, proof, err = blake.GetWithProof([]byte("k11"))
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify(blake.Hash()))
+	require.NoError(t, proof.VerifyAbsence([]byte("k11")))
+
+	keys, values, proof, err := blake.GetRangeWithProof([]byte("k20"), []byte("k40"), 0)
+	require.NoError(t, err)
+	require.Len(t, keys, 10)
+	require.NoError(t, proof.Verify(blake.Hash()))
+	for i, key := range keys {
+		require.NoError(t, proof.VerifyItem(key, values[i]))
+	}
+
+	// Decoded proofs must be configured with the hasher.
+	decoded := &RangeProof{LeftPath: proof.LeftPath, InnerNodes: proof.InnerNodes, Leaves: proof.Leaves}
+	require.Error(t, decoded.Verify(blake.Hash()))
+	decoded.SetHasher(Blake2b256)
+	require.NoError(t, decoded.Verify(blake.Hash()))
+}
+
+func TestHasherRecorded(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := newHasherTestTree(t, memDB, Blake2b256)
+	hash := tree.Hash()
+
+	// The tree can only be loaded with the hasher it was written with.
+	_, err := NewMutableTree(memDB, 0).Load()
+	require.Error(t, err)
+	_, err = NewMutableTreeWithOpts(memDB, 0, &Options{Hasher: SHA256}).LoadVersion(1)
+	require.Error(t, err)
+
+	reloaded := NewMutableTreeWithOpts(memDB, 0, &Options{Hasher: Blake2b256})
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	require.Equal(t, hash, reloaded.Hash())
+	require.True(t, mustVerify(t, memDB).OK())
+
+	// Verify must know the hasher by name.
+	custom := NewHasher("sha512-256", sha512.New512_256)
+	customDB := db.NewMemDB()
+	newHasherTestTree(t, customDB, custom)
+	_, err = Verify(customDB)
+	require.Error(t, err)
+	RegisterHasher(custom)
+	require.True(t, mustVerify(t, customDB).OK())
+}
+
+func TestHasherLegacy(t *testing.T) {
+	// Trees written before the metadata was recorded are SHA256 trees.
+	memDB := db.NewMemDB()
+	tree := newHasherTestTree(t, memDB, nil)
+	memDB.Delete(metadataKeyFormat.Key())
+
+	_, err := NewMutableTreeWithOpts(memDB, 0, &Options{Hasher: Blake2b256}).Load()
+	require.Error(t, err)
+	reloaded := NewMutableTree(memDB, 0)
+	_, err = reloaded.Load()
+	require.NoError(t, err)
+	require.Equal(t, tree.Hash(), reloaded.Hash())
+
+	// The hasher is recorded in the metadata of the next version.
+	reloaded.Set([]byte("new"), []byte("value"))
+	_, _, err = reloaded.SaveVersion()
+	require.NoError(t, err)
+	require.NotNil(t, memDB.Get(metadataKeyFormat.Key()))
+	meta, err := ReadMetadata(memDB)
+	require.NoError(t, err)
+	require.Equal(t, SHA256.Name(), meta.Hasher)
+}
+
+func TestNewHasherSize(t *testing.T) {
+	require.Panics(t, func() { NewHasher("sha512", sha512.New) })
+}
@@ -3,17 +3,29 @@ package iavl
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	dbm "github.com/tendermint/tm-db"
 )
 
 // ImmutableTree is a container for an immutable AVL+ ImmutableTree. Changes are performed by
 // swapping the internal root with a new one, while the container is mutable.
-// Note that this tree is not thread-safe.
+// Note that this tree is not thread-safe, except for trees returned by
+// MutableTree.GetImmutable, which can be read concurrently.
 type ImmutableTree struct {
 	root    *Node
 	ndb     *nodeDB
 	version int64
+
+	// Trees returned by GetImmutable may be read concurrently with the
+	// MutableTree, so they only read nodes and not the fast node index.
+	detached bool
+
+	// The root and the generation of the fast node index when the index was
+	// found to be current with the tree. Reads use the index as long as
+	// neither has changed.
+	fastIndexRoot *Node
+	fastIndexGen  uint64
 }
 
 // NewImmutableTree creates both in-memory and persistent instances
@@ -24,7 +36,7 @@ func NewImmutableTree(db dbm.DB, cacheSize int) *ImmutableTree {
 	}
 	return &ImmutableTree{
 		// NodeDB-backed Tree.
-		ndb: newNodeDB(db, cacheSize),
+		ndb: newNodeDB(db, newDefaultCache(cacheSize)),
 	}
 }
 
@@ -111,6 +123,9 @@ func (t *ImmutableTree) Has(key []byte) bool {
 	if t.root == nil {
 		return false
 	}
+	if t.useFastIndex() {
+		return t.ndb.getFastNode(key) != nil
+	}
 	return t.root.has(t, key)
 }
 
@@ -119,7 +134,7 @@ func (t *ImmutableTree) Hash() []byte {
 	if t.root == nil {
 		return nil
 	}
-	hash, _ := t.root.hashWithCount()
+	hash, _ := t.root.hashWithCount(t.hasher())
 	return hash
 }
 
@@ -128,12 +143,38 @@ func (t *ImmutableTree) hashWithCount() ([]byte, int64) {
 	if t.root == nil {
 		return nil, 0
 	}
-	return t.root.hashWithCount()
+	return t.root.hashWithCount(t.hasher())
+}
+
+// hasher returns the hash function of the tree.
+func (t *ImmutableTree) hasher() Hasher {
+	if t.ndb == nil {
+		return SHA256
+	}
+	return t.ndb.hasher
+}
+
+// Get returns the value of the specified key if it exists, or nil. If the
+// fast node index is current with the tree, the value is read from it
+// without reading any tree nodes.
+func (t *ImmutableTree) Get(key []byte) []byte {
+	if t.root == nil {
+		return nil
+	}
+	if t.useFastIndex() {
+		if fastNode := t.ndb.getFastNode(key); fastNode != nil {
+			return fastNode.value
+		}
+		return nil
+	}
+	_, value := t.root.get(t, key)
+	return value
 }
 
-// Get returns the index and value of the specified key if it exists, or nil
-// and the next index, if it doesn't.
-func (t *ImmutableTree) Get(key []byte) (index int64, value []byte) {
+// GetWithIndex returns the index and value of the specified key if it exists,
+// or nil and the next index, if it doesn't. The index is read from the tree,
+// so unlike Get it is never served by the fast node index.
+func (t *ImmutableTree) GetWithIndex(key []byte) (index int64, value []byte) {
 	if t.root == nil {
 		return 0, nil
 	}
@@ -153,6 +194,11 @@ func (t *ImmutableTree) Iterate(fn func(key []byte, value []byte) bool) (stopped
 	if t.root == nil {
 		return false
 	}
+	if t.useFastIndex() {
+		return t.ndb.traverseFastNodes(nil, nil, true, false, func(fastNode *fastNode) bool {
+			return fn(fastNode.key, fastNode.value)
+		})
+	}
 	return t.root.traverse(t, true, func(node *Node) bool {
 		if node.height == 0 {
 			return fn(node.key, node.value)
@@ -167,6 +213,11 @@ func (t *ImmutableTree) IterateRange(start, end []byte, ascending bool, fn func(
 	if t.root == nil {
 		return false
 	}
+	if t.useFastIndex() {
+		return t.ndb.traverseFastNodes(start, end, ascending, false, func(fastNode *fastNode) bool {
+			return fn(fastNode.key, fastNode.value)
+		})
+	}
 	return t.root.traverseInRange(t, start, end, ascending, false, 0, func(node *Node, _ uint8) bool {
 		if node.height == 0 {
 			return fn(node.key, node.value)
@@ -181,6 +232,11 @@ func (t *ImmutableTree) IterateRangeInclusive(start, end []byte, ascending bool,
 	if t.root == nil {
 		return false
 	}
+	if t.useFastIndex() {
+		return t.ndb.traverseFastNodes(start, end, ascending, true, func(fastNode *fastNode) bool {
+			return fn(fastNode.key, fastNode.value, fastNode.version)
+		})
+	}
 	return t.root.traverseInRange(t, start, end, ascending, true, 0, func(node *Node, _ uint8) bool {
 		if node.height == 0 {
 			return fn(node.key, node.value, node.version)
@@ -189,13 +245,41 @@ func (t *ImmutableTree) IterateRangeInclusive(start, end []byte, ascending bool,
 	})
 }
 
+// useFastIndex returns whether reads can be served from the fast node index,
+// which is only the case if markFastIndex found it current with this tree, and
+// neither the tree's root nor the index have changed since. Modifying a
+// working tree replaces its root.
+func (t *ImmutableTree) useFastIndex() bool {
+	return !t.detached && t.root != nil && t.root == t.fastIndexRoot &&
+		atomic.LoadUint64(&t.ndb.fastIndexGen) == t.fastIndexGen
+}
+
+// markFastIndex records whether the fast node index is current with the tree.
+// It must be called whenever a saved version is loaded or saved.
+func (t *ImmutableTree) markFastIndex() {
+	t.fastIndexRoot = nil
+	if t.detached || t.ndb == nil || t.root == nil || t.root.hash == nil {
+		return
+	}
+	// The generation is read first, so that a concurrent change of the index
+	// leaves it stale rather than the tree marked.
+	gen := atomic.LoadUint64(&t.ndb.fastIndexGen)
+	if t.ndb.hasFastIndex(t.root.hash) {
+		t.fastIndexRoot, t.fastIndexGen = t.root, gen
+	}
+}
+
 // Clone creates a clone of the tree.
 // Used internally by MutableTree.
 func (t *ImmutableTree) clone() *ImmutableTree {
 	return &ImmutableTree{
-		root:    t.root,
-		ndb:     t.ndb,
-		version: t.version,
+		root:     t.root,
+		ndb:      t.ndb,
+		version:  t.version,
+		detached: t.detached,
+
+		fastIndexRoot: t.fastIndexRoot,
+		fastIndexGen:  t.fastIndexGen,
 	}
 }
 
@@ -0,0 +1,173 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// importBatchSize is the number of nodes written to a batch before it is
+// flushed to disk.
+const importBatchSize = 10000
+
+// Importer rebuilds a tree version from a stream of nodes produced by an
+// Exporter. Nodes must be added in the same post-order as they were
+// exported. They are written to the database in batches as they are added,
+// but no version refers to them until Commit saves the root, once the rebuilt
+// root hash matches the expected one. If it doesn't, or if Add fails, the
+// nodes are deleted and the Importer can no longer be used.
+type Importer struct {
+	tree    *MutableTree
+	version int64
+	hash    []byte
+	stack   []*Node
+	hashes  [][]byte // Hashes of the nodes in the current batch.
+	batches int64
+	closed  bool
+}
+
+// Import returns an Importer for the given version and expected root hash.
+// The tree must be empty, i.e. no versions may have been saved to its
+// database. If the import is not committed, the nodes written so far are
+// deleted by the next load of the tree.
+func (tree *MutableTree) Import(version int64, hash []byte) (*Importer, error) {
+	if version <= 0 {
+		return nil, errors.New("imported version must be greater than 0")
+	}
+	if len(tree.versions) > 0 || tree.ndb.getLatestVersion() > 0 {
+		return nil, errors.New("tree must be empty to import a version")
+	}
+	// Nodes are written in several batches. If the import is interrupted,
+	// loading the tree deletes them.
+	tree.ndb.setPending(version)
+	return &Importer{
+		tree:    tree,
+		version: version,
+		hash:    hash,
+	}, nil
+}
+
+// Add adds an exported node to the tree being rebuilt. If the node is invalid,
+// the nodes written so far are deleted, and the import must be started again.
+func (i *Importer) Add(exportNode *ExportNode) error {
+	if i.closed {
+		return errors.New("importer is closed")
+	}
+	if err := i.add(exportNode); err != nil {
+		return i.abort(err)
+	}
+	return nil
+}
+
+func (i *Importer) add(exportNode *ExportNode) error {
+	if exportNode == nil {
+		return errors.New("node cannot be nil")
+	}
+	if exportNode.Version > i.version {
+		return errors.Errorf("node version %d can't be greater than import version %d",
+			exportNode.Version, i.version)
+	}
+
+	node := &Node{
+		key:     exportNode.Key,
+		value:   exportNode.Value,
+		version: exportNode.Version,
+		height:  exportNode.Height,
+	}
+
+	if node.isLeaf() {
+		if node.value == nil {
+			node.value = []byte{}
+		}
+		node.size = 1
+	} else {
+		if len(i.stack) < 2 {
+			return errors.Errorf("inner node at height %d is missing children", node.height)
+		}
+		left, right := i.stack[len(i.stack)-2], i.stack[len(i.stack)-1]
+		i.stack = i.stack[:len(i.stack)-2]
+
+		if node.height != maxInt8(left.height, right.height)+1 {
+			return errors.Errorf("inner node has height %d, but its children have heights %d and %d",
+				node.height, left.height, right.height)
+		}
+		if bytes.Compare(left.key, node.key) >= 0 {
+			return errors.Errorf("inner node key %X is not greater than its left child key %X",
+				node.key, left.key)
+		}
+		node.size = left.size + right.size
+		node.leftHash = left.hash
+		node.rightHash = right.hash
+	}
+
+	node._hash(i.tree.ndb.hasher)
+	i.tree.ndb.SaveNode(node)
+	i.hashes = append(i.hashes, node.hash)
+	if len(i.hashes) >= importBatchSize {
+		i.commitBatch()
+	}
+
+	i.stack = append(i.stack, node)
+	return nil
+}
+
+// Commit checks that the rebuilt tree has the expected root hash, saves it as
+// the imported version and loads it into the MutableTree. If the rebuilt tree
+// is invalid, the nodes written so far are deleted, and the import must be
+// started again.
+func (i *Importer) Commit() error {
+	if i.closed {
+		return errors.New("importer is closed")
+	}
+	var rootHash []byte
+	switch len(i.stack) {
+	case 0:
+		// An empty tree has a nil hash.
+	case 1:
+		rootHash = i.stack[0].hash
+	default:
+		return i.abort(errors.Errorf("invalid node structure, found %d dangling subtrees", len(i.stack)))
+	}
+	if !bytes.Equal(rootHash, i.hash) {
+		return i.abort(errors.Errorf("imported tree hash %X does not match expected hash %X", rootHash, i.hash))
+	}
+
+	i.tree.ndb.resetLatestVersion(i.version - 1)
+	var err error
+	if len(i.stack) == 0 {
+		err = i.tree.ndb.SaveEmptyRoot(i.version)
+	} else {
+		err = i.tree.ndb.SaveRoot(i.stack[0], i.version)
+	}
+	if err != nil {
+		return i.abort(err)
+	}
+	i.tree.ndb.clearPending(i.version)
+	i.tree.ndb.Commit()
+	i.stack = nil
+	i.closed = true
+
+	_, err = i.tree.LoadVersion(i.version)
+	return err
+}
+
+// commitBatch writes the current batch of nodes, recording their hashes so
+// that they can be deleted if the import is rolled back.
+func (i *Importer) commitBatch() {
+	i.tree.ndb.savePendingNodes(i.version, i.batches, i.hashes)
+	i.tree.ndb.Commit()
+	i.hashes = nil
+	i.batches++
+}
+
+// abort deletes the nodes written by the import, which no version refers to,
+// and returns err.
+func (i *Importer) abort(err error) error {
+	i.stack = nil
+	i.closed = true
+	i.commitBatch()
+	if rollbackErr := i.tree.ndb.rollbackPending(); rollbackErr != nil {
+		return errors.Wrapf(rollbackErr, "deleting imported nodes after error %q", err)
+	}
+	return err
+}
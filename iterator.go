@@ -0,0 +1,120 @@
+package iavl
+
+import (
+	"bytes"
+
+	dbm "github.com/tendermint/tm-db"
+)
+
+// Iterator is a dbm.Iterator over a range of keys of an ImmutableTree. It
+// walks the tree on the calling goroutine as it is advanced, in the same
+// order as IterateRange.
+type Iterator struct {
+	start, end []byte
+	ascending  bool
+	t          *ImmutableTree
+
+	key, value []byte
+	valid      bool
+
+	// stack holds the subtrees still to visit, the next one on top.
+	stack []*Node
+}
+
+var _ dbm.Iterator = (*Iterator)(nil)
+
+// Iterator returns an iterator over the keys of the tree between start and
+// end non-inclusive. If either are nil, then it is open on that side.
+func (t *ImmutableTree) Iterator(start, end []byte, ascending bool) *Iterator {
+	iter := &Iterator{
+		start:     start,
+		end:       end,
+		ascending: ascending,
+		t:         t,
+	}
+	if t.root != nil {
+		iter.stack = append(iter.stack, t.root)
+	}
+	iter.next()
+	return iter
+}
+
+// Domain implements dbm.Iterator.
+func (iter *Iterator) Domain() (start, end []byte) {
+	return iter.start, iter.end
+}
+
+// Valid implements dbm.Iterator.
+func (iter *Iterator) Valid() bool {
+	return iter.valid
+}
+
+// Next implements dbm.Iterator.
+func (iter *Iterator) Next() {
+	iter.assertValid()
+	iter.next()
+}
+
+// next moves to the next leaf in range, skipping the subtrees that are out
+// of range as traverseInRange does.
+func (iter *Iterator) next() {
+	for len(iter.stack) > 0 {
+		node := iter.stack[len(iter.stack)-1]
+		iter.stack = iter.stack[:len(iter.stack)-1]
+
+		afterStart := iter.start == nil || bytes.Compare(iter.start, node.key) < 0
+		beforeEnd := iter.end == nil || bytes.Compare(node.key, iter.end) < 0
+		if node.isLeaf() {
+			startOrAfter := afterStart || bytes.Equal(iter.start, node.key)
+			if startOrAfter && beforeEnd {
+				iter.key, iter.value, iter.valid = node.key, node.value, true
+				return
+			}
+			continue
+		}
+
+		// Push the subtree to visit first last.
+		if iter.ascending {
+			if beforeEnd {
+				iter.stack = append(iter.stack, node.getRightNode(iter.t))
+			}
+			if afterStart {
+				iter.stack = append(iter.stack, node.getLeftNode(iter.t))
+			}
+		} else {
+			if afterStart {
+				iter.stack = append(iter.stack, node.getLeftNode(iter.t))
+			}
+			if beforeEnd {
+				iter.stack = append(iter.stack, node.getRightNode(iter.t))
+			}
+		}
+	}
+	iter.key, iter.value, iter.valid = nil, nil, false
+}
+
+// Key implements dbm.Iterator.
+func (iter *Iterator) Key() []byte {
+	iter.assertValid()
+	return iter.key
+}
+
+// Value implements dbm.Iterator.
+func (iter *Iterator) Value() []byte {
+	iter.assertValid()
+	return iter.value
+}
+
+// Close implements dbm.Iterator. It makes the iterator invalid, and releases
+// the tree.
+func (iter *Iterator) Close() {
+	iter.stack = nil
+	iter.t = nil
+	iter.key, iter.value, iter.valid = nil, nil, false
+}
+
+func (iter *Iterator) assertValid() {
+	if !iter.valid {
+		panic("iterator is invalid")
+	}
+}
@@ -0,0 +1,87 @@
+package iavl
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestIterator(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 200; i++ {
+		tree.Set([]byte(cmn.RandStr(2)), []byte(cmn.RandStr(4)))
+	}
+
+	for _, bounds := range [][2][]byte{{nil, nil}, {[]byte("b"), []byte("m")}, {[]byte("m"), nil}, {nil, []byte("c")}, {[]byte("x"), []byte("a")}} {
+		for _, ascending := range []bool{true, false} {
+			expected := [][]byte{}
+			tree.IterateRange(bounds[0], bounds[1], ascending, func(key, value []byte) bool {
+				expected = append(expected, key, value)
+				return false
+			})
+
+			actual := [][]byte{}
+			var iter db.Iterator = tree.Iterator(bounds[0], bounds[1], ascending)
+			start, end := iter.Domain()
+			require.Equal(t, bounds[0], start)
+			require.Equal(t, bounds[1], end)
+			for ; iter.Valid(); iter.Next() {
+				actual = append(actual, iter.Key(), iter.Value())
+			}
+			iter.Close()
+			require.Equal(t, expected, actual)
+			require.Panics(t, func() { iter.Next() })
+			require.Panics(t, func() { iter.Key() })
+		}
+	}
+}
+
+func TestIteratorEmptyTree(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	iter := tree.Iterator(nil, nil, true)
+	require.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestIteratorClose(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	tree.Set([]byte("a"), []byte("1"))
+	tree.Set([]byte("b"), []byte("2"))
+	tree.Set([]byte("c"), []byte("3"))
+
+	iter := tree.Iterator(nil, nil, true)
+	require.True(t, iter.Valid())
+	require.Equal(t, []byte("a"), iter.Key())
+	iter.Close()
+	require.False(t, iter.Valid())
+	require.Panics(t, func() { iter.Value() })
+	iter.Close()
+}
+
+func TestIteratorSaveVersion(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 100; i++ {
+		tree.Set(i2b(i), i2b(i))
+	}
+	goroutines := runtime.NumGoroutine()
+
+	// The working tree is saved and modified while it is iterated, and the
+	// iterator is never closed. It still reads the tree as it was created.
+	iter := tree.Iterator(nil, nil, true)
+	require.Equal(t, goroutines, runtime.NumGoroutine())
+	for i := 0; i < 100; i++ {
+		require.True(t, iter.Valid())
+		require.Equal(t, i2b(i), iter.Key())
+		require.Equal(t, i2b(i), iter.Value())
+		if i%10 == 0 {
+			tree.Set(i2b(i+1), []byte("new"))
+			_, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+		}
+		iter.Next()
+	}
+	require.False(t, iter.Valid())
+}
@@ -0,0 +1,41 @@
+package iavl
+
+// WriteListener is notified of the changes made to a MutableTree, for
+// example to maintain secondary indexes or replicate the tree.
+type WriteListener interface {
+	// OnWrite is called after each Set or Remove that changes the working
+	// tree. Removals of keys that don't exist, and Sets of a key to the value
+	// it already has, are not reported.
+	OnWrite(change *Change)
+
+	// OnCommit is called by SaveVersion after a new version is saved, with the
+	// changes made since the previous version in the order they were made.
+	// Changes that were discarded by Rollback or by loading a version are not
+	// included.
+	OnCommit(version int64, rootHash []byte, changes []*Change)
+}
+
+// AddListener registers a listener to be notified of changes to the tree.
+// Listeners are called synchronously, in the order they were added.
+func (tree *MutableTree) AddListener(listener WriteListener) {
+	tree.listeners = append(tree.listeners, listener)
+}
+
+// notifyWrite records a change of the working tree and reports it to the
+// listeners.
+func (tree *MutableTree) notifyWrite(change *Change) {
+	tree.unsavedChanges = append(tree.unsavedChanges, change)
+	for _, listener := range tree.listeners {
+		listener.OnWrite(change)
+	}
+}
+
+// notifyCommit reports the changes of the just saved version to the
+// listeners.
+func (tree *MutableTree) notifyCommit(version int64, rootHash []byte) {
+	changes := tree.unsavedChanges
+	tree.unsavedChanges = nil
+	for _, listener := range tree.listeners {
+		listener.OnCommit(version, rootHash, changes)
+	}
+}
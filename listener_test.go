@@ -0,0 +1,95 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+type commit struct {
+	version  int64
+	rootHash []byte
+	changes  []*Change
+}
+
+type recordingListener struct {
+	writes  []*Change
+	commits []commit
+}
+
+func (l *recordingListener) OnWrite(change *Change) {
+	l.writes = append(l.writes, change)
+}
+
+func (l *recordingListener) OnCommit(version int64, rootHash []byte, changes []*Change) {
+	l.commits = append(l.commits, commit{version, rootHash, changes})
+}
+
+func TestWriteListener(t *testing.T) {
+	for _, opts := range []*Options{DefaultOptions(), {KeepInMemory: true, FlushInterval: 2, Pruning: NewPruningOptions(1, 0, 0)}} {
+		tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, opts)
+		listener := &recordingListener{}
+		tree.AddListener(listener)
+
+		tree.Set([]byte("a"), []byte("1"))
+		tree.Set([]byte("b"), []byte("2"))
+		tree.Set([]byte("a"), []byte("3"))
+		tree.Set([]byte("a"), []byte("3")) // Not a change.
+		tree.Remove([]byte("c"))
+		tree.Remove([]byte("b"))
+		hash, version, err := tree.SaveVersion()
+		require.NoError(t, err)
+
+		expected := []*Change{
+			{Type: ChangeAdd, Key: []byte("a"), NewValue: []byte("1")},
+			{Type: ChangeAdd, Key: []byte("b"), NewValue: []byte("2")},
+			{Type: ChangeUpdate, Key: []byte("a"), OldValue: []byte("1"), NewValue: []byte("3")},
+			{Type: ChangeRemove, Key: []byte("b"), OldValue: []byte("2")},
+		}
+		require.Equal(t, expected, listener.writes)
+		require.Equal(t, []commit{{version, hash, expected}}, listener.commits)
+
+		// Rolled back changes are reported as writes, but not committed.
+		tree.Set([]byte("x"), []byte("x"))
+		tree.Rollback()
+		tree.Set([]byte("y"), []byte("y"))
+		hash, version, err = tree.SaveVersion()
+		require.NoError(t, err)
+
+		require.Len(t, listener.writes, 6)
+		require.Equal(t, commit{version, hash, []*Change{
+			{Type: ChangeAdd, Key: []byte("y"), NewValue: []byte("y")},
+		}}, listener.commits[1])
+
+		// Versions without changes are committed too.
+		hash, version, err = tree.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, commit{version, hash, nil}, listener.commits[2])
+	}
+}
+
+func TestWriteListenerResave(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTree(memDB, 0)
+	for _, key := range []string{"a", "b"} {
+		tree.Set([]byte(key), []byte(key))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	tree = NewMutableTree(memDB, 0)
+	_, err := tree.LoadVersion(1)
+	require.NoError(t, err)
+	listener := &recordingListener{}
+	tree.AddListener(listener)
+
+	// Saving an existing version again commits its changes too.
+	tree.Set([]byte("b"), []byte("b"))
+	hash, version, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), version)
+	require.Equal(t, []commit{{version, hash, []*Change{
+		{Type: ChangeAdd, Key: []byte("b"), NewValue: []byte("b")},
+	}}}, listener.commits)
+}
@@ -1,11 +1,21 @@
 package iavl
 
-import (
-	"fmt"
-)
-
-func debug(format string, args ...interface{}) {
-	if false {
-		fmt.Printf(format, args...)
-	}
+// Logger is a leveled, structured logger. Events are a message followed by
+// alternating keys and values. It is a subset of the tendermint libs/log
+// Logger interface, so tendermint loggers can be used directly.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
 }
+
+// NewNopLogger returns a logger that discards all events. It is the default.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...interface{}) {}
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
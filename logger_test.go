@@ -0,0 +1,75 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/libs/log"
+	db "github.com/tendermint/tm-db"
+)
+
+// Tendermint loggers can be used directly.
+var _ Logger = log.NewNopLogger()
+
+type logEvent struct {
+	level, msg string
+	keyvals    []interface{}
+}
+
+type recordingLogger struct {
+	events []logEvent
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) {
+	l.events = append(l.events, logEvent{"debug", msg, keyvals})
+}
+
+func (l *recordingLogger) Info(msg string, keyvals ...interface{}) {
+	l.events = append(l.events, logEvent{"info", msg, keyvals})
+}
+
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) {
+	l.events = append(l.events, logEvent{"error", msg, keyvals})
+}
+
+func (l *recordingLogger) find(level, msg string) []logEvent {
+	events := []logEvent{}
+	for _, event := range l.events {
+		if event.level == level && event.msg == msg {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	logger := &recordingLogger{}
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{
+		Logger:  logger,
+		Pruning: NewPruningOptions(2, 0, 0),
+	})
+	for v := byte(1); v <= 4; v++ {
+		for i := byte(0); i < 100; i++ {
+			tree.Set([]byte{i}, []byte{v})
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, []logEvent{
+		{"info", "pruning versions", []interface{}{"from", int64(1), "to", int64(2), "latest", int64(3)}},
+		{"info", "pruning versions", []interface{}{"from", int64(2), "to", int64(3), "latest", int64(4)}},
+	}, logger.find("info", "pruning versions"))
+	require.Len(t, logger.find("debug", "save version"), 4)
+	require.Len(t, logger.find("debug", "deleted orphans"), 2)
+	// Every version rewrites all 199 nodes of the tree.
+	require.Len(t, logger.find("debug", "save node"), 4*199)
+	require.Equal(t, []interface{}{"hash", tree.root.hash, "version", int64(4)},
+		logger.find("debug", "save node")[4*199-1].keyvals)
+	require.Len(t, logger.find("debug", "save orphan"), 3*199)
+	require.NotEmpty(t, logger.find("debug", "delete orphan"))
+	require.Empty(t, logger.find("error", "failed to prune versions"))
+
+	// Per-node events are skipped for the nop logger.
+	require.False(t, NewMutableTree(db.NewMemDB(), 0).ndb.logNodes)
+}
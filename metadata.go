@@ -0,0 +1,129 @@
+package iavl
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tm-db"
+)
+
+const (
+	// NodeEncodingVersion is the version of the node encoding written by
+	// this release.
+	NodeEncodingVersion = 1
+
+	// KeyFormatVersion is the version of the database key layout written by
+	// this release.
+	KeyFormatVersion = 1
+)
+
+// ErrIncompatibleTree is returned when loading a tree that was written in a
+// format this release can't read, or with different options.
+var ErrIncompatibleTree = fmt.Errorf("incompatible tree")
+
+// Metadata describes how the tree in a database was written. It is recorded
+// when the first version is saved, and checked when the tree is loaded.
+type Metadata struct {
+	NodeEncoding int    `json:"node_encoding"`
+	KeyFormat    int    `json:"key_format"`
+	Hasher       string `json:"hasher"`  // Name of the hash function of the nodes.
+	Release      string `json:"release"` // Version of iavl, if set by build flags.
+}
+
+// legacyMetadata is the metadata of trees written before it was recorded.
+var legacyMetadata = Metadata{
+	NodeEncoding: 1,
+	KeyFormat:    1,
+	Hasher:       SHA256.Name(),
+}
+
+// ReadMetadata returns the metadata of the tree in db, or nil if no version
+// has been saved. Trees written before metadata was recorded are reported
+// as such, with an empty Release.
+func ReadMetadata(db dbm.DB) (*Metadata, error) {
+	ndb := newNodeDB(db, newDefaultCache(0))
+	meta, ok, err := ndb.readMetadata()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// readMetadata returns the metadata recorded in the database, and whether any
+// was found. Trees that have saved versions without recording metadata get
+// legacyMetadata.
+func (ndb *nodeDB) readMetadata() (Metadata, bool, error) {
+	var meta Metadata
+	if bz := ndb.db.Get(metadataKeyFormat.Key()); bz != nil {
+		if err := json.Unmarshal(bz, &meta); err != nil {
+			return Metadata{}, false, errors.Wrapf(ErrIncompatibleTree, "decoding tree metadata: %v", err)
+		}
+	} else if ndb.hasRoots() {
+		meta = legacyMetadata
+	} else {
+		return Metadata{}, false, nil
+	}
+	return meta, true, nil
+}
+
+// check returns an error if a tree with the metadata can't be read by this
+// release.
+func (meta Metadata) check() error {
+	switch {
+	case meta.NodeEncoding != NodeEncodingVersion:
+		return errors.Wrapf(ErrIncompatibleTree, "tree uses node encoding version %d, but this release uses %d",
+			meta.NodeEncoding, NodeEncodingVersion)
+	case meta.KeyFormat != KeyFormatVersion:
+		return errors.Wrapf(ErrIncompatibleTree, "tree uses key format version %d, but this release uses %d",
+			meta.KeyFormat, KeyFormatVersion)
+	}
+	return nil
+}
+
+// checkMetadata checks that the tree in the database can be read by this
+// release with the options of this nodeDB.
+func (ndb *nodeDB) checkMetadata() error {
+	if ndb.metadataChecked {
+		return nil
+	}
+	meta, ok, err := ndb.readMetadata()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := meta.check(); err != nil {
+			return err
+		}
+		if meta.Hasher != ndb.hasher.Name() {
+			return errors.Wrapf(ErrIncompatibleTree, "tree was written with hasher %q, but is opened with %q",
+				meta.Hasher, ndb.hasher.Name())
+		}
+	}
+	ndb.metadataChecked = true
+	ndb.metadataWritten = ndb.db.Has(metadataKeyFormat.Key())
+	return nil
+}
+
+// saveMetadata records the metadata in the batch, unless it has already
+// been recorded.
+func (ndb *nodeDB) saveMetadata() error {
+	if err := ndb.checkMetadata(); err != nil {
+		return err
+	}
+	if ndb.metadataWritten {
+		return nil
+	}
+	bz, err := json.Marshal(Metadata{
+		NodeEncoding: NodeEncodingVersion,
+		KeyFormat:    KeyFormatVersion,
+		Hasher:       ndb.hasher.Name(),
+		Release:      Version,
+	})
+	if err != nil {
+		return err
+	}
+	ndb.batch.Set(metadataKeyFormat.Key(), bz)
+	ndb.metadataWritten = true
+	return nil
+}
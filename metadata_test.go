@@ -0,0 +1,82 @@
+package iavl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestMetadata(t *testing.T) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTree(memDB, 0)
+	_, err := tree.Load()
+	require.NoError(t, err)
+	meta, err := ReadMetadata(memDB)
+	require.NoError(t, err)
+	require.Nil(t, meta)
+
+	// The metadata is written with the first version.
+	tree.Set([]byte("a"), []byte("1"))
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	meta, err = ReadMetadata(memDB)
+	require.NoError(t, err)
+	require.Equal(t, &Metadata{NodeEncoding: NodeEncodingVersion, KeyFormat: KeyFormatVersion, Hasher: "sha256"}, meta)
+	require.JSONEq(t, `{"node_encoding":1,"key_format":1,"hasher":"sha256","release":""}`,
+		string(memDB.Get(metadataKeyFormat.Key())))
+
+	// Trees written before metadata was recorded are reported as legacy.
+	memDB.Delete(metadataKeyFormat.Key())
+	meta, err = ReadMetadata(memDB)
+	require.NoError(t, err)
+	require.Equal(t, &Metadata{NodeEncoding: 1, KeyFormat: 1, Hasher: "sha256"}, meta)
+}
+
+func TestMetadataIncompatible(t *testing.T) {
+	testCases := map[string]func(meta *Metadata){
+		"node encoding": func(meta *Metadata) { meta.NodeEncoding = NodeEncodingVersion + 1 },
+		"key format":    func(meta *Metadata) { meta.KeyFormat = KeyFormatVersion + 1 },
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			memDB := db.NewMemDB()
+			tree := NewMutableTree(memDB, 0)
+			tree.Set([]byte("a"), []byte("1"))
+			_, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+
+			meta, err := ReadMetadata(memDB)
+			require.NoError(t, err)
+			tc(meta)
+			bz, err := json.Marshal(meta)
+			require.NoError(t, err)
+			memDB.Set(metadataKeyFormat.Key(), bz)
+
+			_, err = NewMutableTree(memDB, 0).Load()
+			require.Equal(t, ErrIncompatibleTree, errors.Cause(err))
+			_, err = NewMutableTree(memDB, 0).LazyLoadVersion(0)
+			require.Equal(t, ErrIncompatibleTree, errors.Cause(err))
+		})
+	}
+
+	// So are trees written with another hasher.
+	memDB := db.NewMemDB()
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{Hasher: Blake2b256})
+	tree.Set([]byte("a"), []byte("1"))
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	_, err = NewMutableTree(memDB, 0).Load()
+	require.Equal(t, ErrIncompatibleTree, errors.Cause(err))
+
+	// Metadata that can't be decoded is also incompatible.
+	memDB = db.NewMemDB()
+	memDB.Set(metadataKeyFormat.Key(), []byte{0xff})
+	_, err = NewMutableTree(memDB, 0).Load()
+	require.Equal(t, ErrIncompatibleTree, errors.Cause(err))
+	_, err = Verify(memDB)
+	require.Equal(t, ErrIncompatibleTree, errors.Cause(err))
+}
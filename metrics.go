@@ -0,0 +1,63 @@
+package iavl
+
+import (
+	"time"
+)
+
+// Metrics receives measurements of the operations of a tree, for example to
+// export them to Prometheus as the metrics subpackage does. Nodes are read
+// concurrently, so implementations must be safe for concurrent use.
+type Metrics interface {
+	// CacheHit is called when a node is found in the node cache.
+	CacheHit()
+	// CacheMiss is called when a node is not found in the node cache.
+	CacheMiss()
+	// DBRead is called when a node of the given size is read from the database.
+	DBRead(bytes int)
+	// NodeSaved is called when a node of the given size is written.
+	NodeSaved(bytes int)
+	// OrphansSaved is called with the number of nodes orphaned by a version.
+	OrphansSaved(count int)
+	// OrphansDeleted is called with the number of orphaned nodes deleted from
+	// disk when deleting versions.
+	OrphansDeleted(count int)
+	// SaveVersion is called with the duration of MutableTree.SaveVersion.
+	SaveVersion(duration time.Duration)
+	// DeleteVersion is called with the duration of deleting one or more
+	// versions, including pruning.
+	DeleteVersion(duration time.Duration)
+	// TreeShape is called with the height and size of each saved version.
+	TreeShape(height int8, size int64)
+}
+
+// NopMetrics is a Metrics that discards all measurements. It is the default.
+type NopMetrics struct{}
+
+var _ Metrics = NopMetrics{}
+
+// CacheHit implements Metrics.
+func (NopMetrics) CacheHit() {}
+
+// CacheMiss implements Metrics.
+func (NopMetrics) CacheMiss() {}
+
+// DBRead implements Metrics.
+func (NopMetrics) DBRead(int) {}
+
+// NodeSaved implements Metrics.
+func (NopMetrics) NodeSaved(int) {}
+
+// OrphansSaved implements Metrics.
+func (NopMetrics) OrphansSaved(int) {}
+
+// OrphansDeleted implements Metrics.
+func (NopMetrics) OrphansDeleted(int) {}
+
+// SaveVersion implements Metrics.
+func (NopMetrics) SaveVersion(time.Duration) {}
+
+// DeleteVersion implements Metrics.
+func (NopMetrics) DeleteVersion(time.Duration) {}
+
+// TreeShape implements Metrics.
+func (NopMetrics) TreeShape(int8, int64) {}
@@ -0,0 +1,162 @@
+// Package metrics implements iavl.Metrics with go-kit metrics, which can be
+// exported to Prometheus.
+package metrics
+
+import (
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tendermint/iavl"
+)
+
+// MetricsSubsystem is the subsystem label of the metrics.
+const MetricsSubsystem = "iavl"
+
+// Metrics contains the metrics of a tree.
+type Metrics struct {
+	// Number of nodes found in the node cache.
+	CacheHits metrics.Counter
+	// Number of nodes not found in the node cache.
+	CacheMisses metrics.Counter
+	// Number of nodes read from the database.
+	DBReads metrics.Counter
+	// Bytes of nodes read from the database.
+	DBReadBytes metrics.Counter
+	// Number of nodes written.
+	NodesSaved metrics.Counter
+	// Bytes of nodes written.
+	NodesSavedBytes metrics.Counter
+	// Number of orphaned nodes written.
+	OrphanedNodes metrics.Counter
+	// Number of orphaned nodes deleted.
+	DeletedOrphans metrics.Counter
+	// Duration of saving a version, in seconds.
+	SaveVersionSeconds metrics.Histogram
+	// Duration of deleting versions, in seconds.
+	DeleteVersionSeconds metrics.Histogram
+	// Height of the latest saved version.
+	TreeHeight metrics.Gauge
+	// Number of keys of the latest saved version.
+	TreeSize metrics.Gauge
+}
+
+var _ iavl.Metrics = (*Metrics)(nil)
+
+// PrometheusMetrics returns Metrics built using the Prometheus client library.
+// Optionally, labels can be provided along with their values ("foo",
+// "fooValue").
+func PrometheusMetrics(namespace string, labelsAndValues ...string) *Metrics {
+	labels := []string{}
+	for i := 0; i < len(labelsAndValues); i += 2 {
+		labels = append(labels, labelsAndValues[i])
+	}
+	counter := func(name, help string) metrics.Counter {
+		return prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+		}, labels).With(labelsAndValues...)
+	}
+	gauge := func(name, help string) metrics.Gauge {
+		return prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+		}, labels).With(labelsAndValues...)
+	}
+	histogram := func(name, help string) metrics.Histogram {
+		return prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: MetricsSubsystem,
+			Name:      name,
+			Help:      help,
+			Buckets:   stdprometheus.ExponentialBuckets(0.001, 4, 8),
+		}, labels).With(labelsAndValues...)
+	}
+	return &Metrics{
+		CacheHits:            counter("cache_hits", "Number of nodes found in the node cache."),
+		CacheMisses:          counter("cache_misses", "Number of nodes not found in the node cache."),
+		DBReads:              counter("db_reads", "Number of nodes read from the database."),
+		DBReadBytes:          counter("db_read_bytes", "Bytes of nodes read from the database."),
+		NodesSaved:           counter("nodes_saved", "Number of nodes written."),
+		NodesSavedBytes:      counter("nodes_saved_bytes", "Bytes of nodes written."),
+		OrphanedNodes:        counter("orphans_saved", "Number of orphaned nodes written."),
+		DeletedOrphans:       counter("orphans_deleted", "Number of orphaned nodes deleted."),
+		SaveVersionSeconds:   histogram("save_version_seconds", "Duration of saving a version, in seconds."),
+		DeleteVersionSeconds: histogram("delete_version_seconds", "Duration of deleting versions, in seconds."),
+		TreeHeight:           gauge("tree_height", "Height of the latest saved version."),
+		TreeSize:             gauge("tree_size", "Number of keys of the latest saved version."),
+	}
+}
+
+// NopMetrics returns no-op Metrics.
+func NopMetrics() *Metrics {
+	return &Metrics{
+		CacheHits:            discard.NewCounter(),
+		CacheMisses:          discard.NewCounter(),
+		DBReads:              discard.NewCounter(),
+		DBReadBytes:          discard.NewCounter(),
+		NodesSaved:           discard.NewCounter(),
+		NodesSavedBytes:      discard.NewCounter(),
+		OrphanedNodes:        discard.NewCounter(),
+		DeletedOrphans:       discard.NewCounter(),
+		SaveVersionSeconds:   discard.NewHistogram(),
+		DeleteVersionSeconds: discard.NewHistogram(),
+		TreeHeight:           discard.NewGauge(),
+		TreeSize:             discard.NewGauge(),
+	}
+}
+
+// CacheHit implements iavl.Metrics.
+func (m *Metrics) CacheHit() {
+	m.CacheHits.Add(1)
+}
+
+// CacheMiss implements iavl.Metrics.
+func (m *Metrics) CacheMiss() {
+	m.CacheMisses.Add(1)
+}
+
+// DBRead implements iavl.Metrics.
+func (m *Metrics) DBRead(bytes int) {
+	m.DBReads.Add(1)
+	m.DBReadBytes.Add(float64(bytes))
+}
+
+// NodeSaved implements iavl.Metrics.
+func (m *Metrics) NodeSaved(bytes int) {
+	m.NodesSaved.Add(1)
+	m.NodesSavedBytes.Add(float64(bytes))
+}
+
+// OrphansSaved implements iavl.Metrics.
+func (m *Metrics) OrphansSaved(count int) {
+	m.OrphanedNodes.Add(float64(count))
+}
+
+// OrphansDeleted implements iavl.Metrics.
+func (m *Metrics) OrphansDeleted(count int) {
+	m.DeletedOrphans.Add(float64(count))
+}
+
+// SaveVersion implements iavl.Metrics.
+func (m *Metrics) SaveVersion(duration time.Duration) {
+	m.SaveVersionSeconds.Observe(duration.Seconds())
+}
+
+// DeleteVersion implements iavl.Metrics.
+func (m *Metrics) DeleteVersion(duration time.Duration) {
+	m.DeleteVersionSeconds.Observe(duration.Seconds())
+}
+
+// TreeShape implements iavl.Metrics.
+func (m *Metrics) TreeShape(height int8, size int64) {
+	m.TreeHeight.Set(float64(height))
+	m.TreeSize.Set(float64(size))
+}
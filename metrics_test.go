@@ -0,0 +1,74 @@
+package iavl
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+type countingMetrics struct {
+	mtx                                    sync.Mutex
+	hits, misses, reads, saved, savedBytes int
+	orphansSaved, orphansDeleted           int
+	saveVersions, deleteVersions           int
+	height                                 int8
+	size                                   int64
+}
+
+func (m *countingMetrics) CacheHit()                   { m.mtx.Lock(); m.hits++; m.mtx.Unlock() }
+func (m *countingMetrics) CacheMiss()                  { m.mtx.Lock(); m.misses++; m.mtx.Unlock() }
+func (m *countingMetrics) DBRead(int)                  { m.mtx.Lock(); m.reads++; m.mtx.Unlock() }
+func (m *countingMetrics) NodeSaved(bytes int)         { m.saved++; m.savedBytes += bytes }
+func (m *countingMetrics) OrphansSaved(count int)      { m.orphansSaved += count }
+func (m *countingMetrics) OrphansDeleted(count int)    { m.orphansDeleted += count }
+func (m *countingMetrics) SaveVersion(time.Duration)   { m.saveVersions++ }
+func (m *countingMetrics) DeleteVersion(time.Duration) { m.deleteVersions++ }
+func (m *countingMetrics) TreeShape(height int8, size int64) {
+	m.height, m.size = height, size
+}
+
+func TestMetrics(t *testing.T) {
+	memDB := db.NewMemDB()
+	metrics := &countingMetrics{}
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{Metrics: metrics})
+
+	for i := byte(0); i < 10; i++ {
+		tree.Set([]byte{i}, []byte{i})
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, 19, metrics.saved)
+	require.True(t, metrics.savedBytes > 0)
+	require.Equal(t, 0, metrics.orphansSaved)
+	require.Equal(t, 1, metrics.saveVersions)
+	require.Equal(t, tree.Height(), metrics.height)
+	require.EqualValues(t, 10, metrics.size)
+
+	// Updating a key replaces the nodes on its path.
+	saved := metrics.saved
+	tree.Set([]byte{0}, []byte{1})
+	_, _, err = tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2, metrics.saveVersions)
+	require.True(t, metrics.orphansSaved > 0)
+	require.Equal(t, metrics.saved-saved, metrics.orphansSaved)
+
+	require.NoError(t, tree.DeleteVersion(1))
+	require.Equal(t, 1, metrics.deleteVersions)
+	require.Equal(t, metrics.orphansSaved, metrics.orphansDeleted)
+
+	// A fresh tree reads the nodes from disk, then from the cache.
+	metrics = &countingMetrics{}
+	tree = NewMutableTreeWithOpts(memDB, 100, &Options{Metrics: metrics})
+	_, err = tree.Load()
+	require.NoError(t, err)
+	require.Equal(t, []byte{5}, tree.Get([]byte{5}))
+	require.True(t, metrics.reads > 0)
+	require.Equal(t, metrics.reads, metrics.misses)
+	hits := metrics.hits
+	tree.ndb.GetNode(tree.root.hash)
+	require.Equal(t, hits+1, metrics.hits)
+}
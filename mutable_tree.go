@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -20,12 +22,57 @@ type MutableTree struct {
 	orphans        map[string]int64 // Nodes removed by changes to working tree.
 	versions       map[int64]bool   // The previous, saved versions of the tree.
 	ndb            *nodeDB
+	opts           *Options
+
+	memVersions      map[int64]*ImmutableTree // Saved versions not yet flushed to disk, in KeepInMemory mode.
+	memMtx           sync.RWMutex             // Guards memVersions, which GetImmutable reads concurrently.
+	unflushedOrphans map[string]int64         // Nodes removed by versions not yet flushed to disk.
+
+	unsavedFastNodes   map[string]*fastNode // Fast node changes of the working tree, nil for removals.
+	unflushedFastNodes map[string]*fastNode // Fast node changes of versions not yet flushed to disk.
+
+	listeners      []WriteListener // Listeners notified of changes to the tree.
+	unsavedChanges []*Change       // Changes to the working tree, if there are listeners.
+
+	batchNodes map[*Node]struct{} // Inner nodes created by the running ApplyBatch.
+	hashSem    chan struct{}      // Workers for hashing besides the calling goroutine, if any.
 }
 
 // NewMutableTree returns a new tree with the specified cache size and datastore.
 func NewMutableTree(db dbm.DB, cacheSize int) *MutableTree {
-	ndb := newNodeDB(db, cacheSize)
+	return NewMutableTreeWithOpts(db, cacheSize, nil)
+}
+
+// NewMutableTreeWithOpts returns a new tree with the specified options. If
+// opts is nil, DefaultOptions are used. The cache holds at most cacheSize
+// nodes and DefaultCacheBytes of memory, unless opts.Cache is set.
+func NewMutableTreeWithOpts(db dbm.DB, cacheSize int, opts *Options) *MutableTree {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+	cache := opts.Cache
+	if cache == nil {
+		cache = newDefaultCache(cacheSize)
+	}
+	ndb := newNodeDB(db, cache)
+	ndb.fastIndex = opts.FastIndex
+	if opts.Metrics != nil {
+		ndb.metrics = opts.Metrics
+	}
+	if opts.Logger != nil {
+		ndb.logger = opts.Logger
+		// Per-node events allocate their arguments even if discarded.
+		_, nop := opts.Logger.(nopLogger)
+		ndb.logNodes = !nop
+	}
+	if opts.Hasher != nil {
+		ndb.hasher = opts.Hasher
+	}
 	head := &ImmutableTree{ndb: ndb}
+	var hashSem chan struct{}
+	if opts.HashWorkers > 1 {
+		hashSem = make(chan struct{}, opts.HashWorkers-1)
+	}
 
 	return &MutableTree{
 		ImmutableTree: head,
@@ -33,6 +80,15 @@ func NewMutableTree(db dbm.DB, cacheSize int) *MutableTree {
 		orphans:       map[string]int64{},
 		versions:      map[int64]bool{},
 		ndb:           ndb,
+		opts:          opts,
+
+		memVersions:      map[int64]*ImmutableTree{},
+		unflushedOrphans: map[string]int64{},
+
+		unsavedFastNodes:   map[string]*fastNode{},
+		unflushedFastNodes: map[string]*fastNode{},
+
+		hashSem: hashSem,
 	}
 }
 
@@ -68,9 +124,18 @@ func (tree *MutableTree) Hash() []byte {
 	return nil
 }
 
+// CacheStats returns the counters of the node cache.
+func (tree *MutableTree) CacheStats() CacheStats {
+	return tree.ndb.nodeCache.Stats()
+}
+
 // WorkingHash returns the hash of the current working tree.
 func (tree *MutableTree) WorkingHash() []byte {
-	return tree.ImmutableTree.Hash()
+	if tree.root == nil {
+		return nil
+	}
+	hash, _ := tree.root.hashWithCountParallel(tree.ndb.hasher, tree.hashSem)
+	return hash
 }
 
 // String returns a string representation of the tree.
@@ -86,28 +151,47 @@ func (tree *MutableTree) prepareOrphansSlice() []*Node {
 
 // Set sets a key in the working tree. Nil values are not supported.
 func (tree *MutableTree) Set(key, value []byte) bool {
-	orphaned, updated := tree.set(key, value)
+	orphaned, oldValue, updated := tree.set(key, value)
 	tree.addOrphans(orphaned)
+	tree.recordSet(key, value, oldValue, updated)
 	return updated
 }
 
-func (tree *MutableTree) set(key []byte, value []byte) (orphans []*Node, updated bool) {
+// recordSet updates the fast index and notifies the listeners of a key that
+// was set.
+func (tree *MutableTree) recordSet(key, value, oldValue []byte, updated bool) {
+	if tree.ndb.fastIndex {
+		tree.unsavedFastNodes[string(key)] = &fastNode{key: key, value: value, version: tree.version + 1}
+	}
+	if len(tree.listeners) > 0 {
+		switch {
+		case !updated:
+			tree.notifyWrite(&Change{Type: ChangeAdd, Key: key, NewValue: value})
+		case !bytes.Equal(oldValue, value):
+			tree.notifyWrite(&Change{Type: ChangeUpdate, Key: key, OldValue: oldValue, NewValue: value})
+		}
+	}
+}
+
+// set sets the key, and returns the orphaned nodes, and the old value if the
+// key was updated.
+func (tree *MutableTree) set(key []byte, value []byte) (orphans []*Node, oldValue []byte, updated bool) {
 	if value == nil {
 		panic(fmt.Sprintf("Attempt to store nil value at key '%s'", key))
 	}
 
 	if tree.ImmutableTree.root == nil {
 		tree.ImmutableTree.root = NewNode(key, value, tree.version+1)
-		return nil, updated
+		return nil, nil, false
 	}
 
 	orphans = tree.prepareOrphansSlice()
-	tree.ImmutableTree.root, updated = tree.recursiveSet(tree.ImmutableTree.root, key, value, &orphans)
-	return orphans, updated
+	tree.ImmutableTree.root, oldValue, updated = tree.recursiveSet(tree.ImmutableTree.root, key, value, &orphans)
+	return orphans, oldValue, updated
 }
 
 func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orphans *[]*Node) (
-	newSelf *Node, updated bool,
+	newSelf *Node, oldValue []byte, updated bool,
 ) {
 	version := tree.version + 1
 
@@ -121,7 +205,7 @@ func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orph
 				leftNode:  NewNode(key, value, version),
 				rightNode: node,
 				version:   version,
-			}, false
+			}, nil, false
 		case 1:
 			return &Node{
 				key:       key,
@@ -130,29 +214,29 @@ func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orph
 				leftNode:  node,
 				rightNode: NewNode(key, value, version),
 				version:   version,
-			}, false
+			}, nil, false
 		default:
 			*orphans = append(*orphans, node)
-			return NewNode(key, value, version), true
+			return NewNode(key, value, version), node.value, true
 		}
 	} else {
 		*orphans = append(*orphans, node)
-		node = node.clone(version)
+		node = tree.mutableNode(node)
 
 		if bytes.Compare(key, node.key) < 0 {
-			node.leftNode, updated = tree.recursiveSet(node.getLeftNode(tree.ImmutableTree), key, value, orphans)
+			node.leftNode, oldValue, updated = tree.recursiveSet(node.getLeftNode(tree.ImmutableTree), key, value, orphans)
 			node.leftHash = nil // leftHash is yet unknown
 		} else {
-			node.rightNode, updated = tree.recursiveSet(node.getRightNode(tree.ImmutableTree), key, value, orphans)
+			node.rightNode, oldValue, updated = tree.recursiveSet(node.getRightNode(tree.ImmutableTree), key, value, orphans)
 			node.rightHash = nil // rightHash is yet unknown
 		}
 
 		if updated {
-			return node, updated
+			return node, oldValue, updated
 		}
 		node.calcHeightAndSize(tree.ImmutableTree)
 		newNode := tree.balance(node, orphans)
-		return newNode, updated
+		return newNode, nil, updated
 	}
 }
 
@@ -160,9 +244,167 @@ func (tree *MutableTree) recursiveSet(node *Node, key []byte, value []byte, orph
 func (tree *MutableTree) Remove(key []byte) ([]byte, bool) {
 	val, orphaned, removed := tree.remove(key)
 	tree.addOrphans(orphaned)
+	if removed {
+		tree.recordRemove(key, val)
+	}
 	return val, removed
 }
 
+// recordRemove updates the fast index and notifies the listeners of a key
+// that was removed.
+func (tree *MutableTree) recordRemove(key, value []byte) {
+	if tree.ndb.fastIndex {
+		tree.unsavedFastNodes[string(key)] = nil
+	}
+	if len(tree.listeners) > 0 {
+		tree.notifyWrite(&Change{Type: ChangeRemove, Key: key, OldValue: value})
+	}
+}
+
+// BatchOp is a write applied by MutableTree.ApplyBatch.
+type BatchOp struct {
+	Key    []byte
+	Value  []byte // Value to set, ignored if Delete is true.
+	Delete bool   // Remove the key instead of setting it.
+}
+
+// ApplyBatch applies the ops to the working tree in a single top-down pass,
+// with the same result as calling Set or Remove for each op in order. Keys
+// must be sorted and unique. Nothing is applied if an op is invalid.
+//
+// The ops are split between the children of each inner node, so each node on
+// the paths to the keys is copied and orphaned once, and nodes created by the
+// batch are modified in place. A subtree returns to its parent whenever its
+// height or smallest key changes, so that its ancestors rebalance and split
+// the remaining ops at the same points as they would after the sequential
+// calls.
+func (tree *MutableTree) ApplyBatch(ops []BatchOp) error {
+	for i, op := range ops {
+		if i > 0 && bytes.Compare(ops[i-1].Key, op.Key) >= 0 {
+			return errors.Errorf("batch keys must be sorted and unique, but %X is followed by %X",
+				ops[i-1].Key, op.Key)
+		}
+		if !op.Delete && op.Value == nil {
+			return errors.Errorf("nil value for key %X", op.Key)
+		}
+	}
+
+	tree.batchNodes = map[*Node]struct{}{}
+	defer func() { tree.batchNodes = nil }()
+	orphans := []*Node{}
+	for len(ops) > 0 {
+		if tree.root == nil {
+			if op := ops[0]; !op.Delete {
+				tree.root = NewNode(op.Key, op.Value, tree.version+1)
+				tree.recordSet(op.Key, op.Value, nil, false)
+			}
+			ops = ops[1:]
+			continue
+		}
+		var applied int
+		tree.root, applied, _, _ = tree.applyBatch(tree.root, ops, &orphans)
+		ops = ops[applied:]
+	}
+	tree.addOrphans(orphans)
+	return nil
+}
+
+// applyBatch applies a prefix of the ops to the subtree of node, until its
+// height or smallest key changes, or all ops are applied. It returns the new
+// subtree, which is nil if its only key was removed, the number of ops
+// applied, whether the subtree changed, and its new smallest key if removals
+// changed it.
+func (tree *MutableTree) applyBatch(node *Node, ops []BatchOp, orphans *[]*Node) (
+	newSelf *Node, applied int, changed bool, newKey []byte,
+) {
+	version := tree.version + 1
+
+	if node.isLeaf() {
+		// Updates and removals of other keys leave the height unchanged.
+		for ; applied < len(ops); applied++ {
+			op := ops[applied]
+			cmp := bytes.Compare(op.Key, node.key)
+			switch {
+			case op.Delete && cmp != 0:
+				continue
+			case op.Delete:
+				*orphans = append(*orphans, node)
+				tree.recordRemove(node.key, node.value)
+				return nil, applied + 1, true, nil
+			case cmp == 0:
+				*orphans = append(*orphans, node)
+				tree.recordSet(op.Key, op.Value, node.value, true)
+				node, changed = NewNode(op.Key, op.Value, version), true
+				continue
+			}
+
+			newNode := &Node{height: 1, size: 2, version: version}
+			if cmp < 0 {
+				newNode.key, newNode.leftNode, newNode.rightNode = node.key, NewNode(op.Key, op.Value, version), node
+			} else {
+				newNode.key, newNode.leftNode, newNode.rightNode = op.Key, node, NewNode(op.Key, op.Value, version)
+			}
+			tree.recordSet(op.Key, op.Value, nil, false)
+			return tree.addBatchNode(newNode), applied + 1, true, nil
+		}
+		return node, applied, changed, nil
+	}
+
+	height := node.height
+	for applied < len(ops) && node.height == height && newKey == nil {
+		// Ops on keys before node.key go to the left subtree, the others to
+		// the right one. node.key may change as ops are applied.
+		rest := ops[applied:]
+		split := sort.Search(len(rest), func(i int) bool { return bytes.Compare(rest[i].Key, node.key) >= 0 })
+		left := split > 0
+		if left {
+			rest = rest[:split]
+		}
+		child := node.getRightNode(tree.ImmutableTree)
+		if left {
+			child = node.getLeftNode(tree.ImmutableTree)
+		}
+		// Nodes created by the batch are modified in place.
+		childHeight, childSize := child.height, child.size
+
+		newChild, n, childChanged, childKey := tree.applyBatch(child, rest, orphans)
+		applied += n
+		if !childChanged {
+			continue
+		}
+		if !changed {
+			*orphans = append(*orphans, node)
+			changed = true
+		}
+		if newChild == nil {
+			// The child was a removed leaf, so the other child replaces
+			// the node, and the smallest key changes if it was the left one.
+			if left {
+				return node.getRightNode(tree.ImmutableTree), applied, true, node.key
+			}
+			return node.getLeftNode(tree.ImmutableTree), applied, true, nil
+		}
+
+		node = tree.mutableNode(node)
+		if left {
+			node.leftHash, node.leftNode = nil, newChild
+			if childKey != nil {
+				newKey = childKey
+			}
+		} else {
+			node.rightHash, node.rightNode = nil, newChild
+			if childKey != nil {
+				node.key = childKey
+			}
+		}
+		if newChild.height != childHeight || newChild.size != childSize {
+			node.calcHeightAndSize(tree.ImmutableTree)
+			node = tree.balance(node, orphans)
+		}
+	}
+	return node, applied, changed, newKey
+}
+
 // remove tries to remove a key from the tree and if removed, returns its
 // value, nodes orphaned and 'true'.
 func (tree *MutableTree) remove(key []byte) (value []byte, orphaned []*Node, removed bool) {
@@ -191,8 +433,6 @@ func (tree *MutableTree) remove(key []byte) (value []byte, orphaned []*Node, rem
 // - the removed value
 // - the orphaned nodes.
 func (tree *MutableTree) recursiveRemove(node *Node, key []byte, orphans *[]*Node) (newHash []byte, newSelf *Node, newKey []byte, newValue []byte) {
-	version := tree.version + 1
-
 	if node.isLeaf() {
 		if bytes.Equal(key, node.key) {
 			*orphans = append(*orphans, node)
@@ -213,7 +453,7 @@ func (tree *MutableTree) recursiveRemove(node *Node, key []byte, orphans *[]*Nod
 			return node.rightHash, node.rightNode, node.key, value
 		}
 
-		newNode := node.clone(version)
+		newNode := tree.mutableNode(node)
 		newNode.leftHash, newNode.leftNode = newLeftHash, newLeftNode
 		newNode.calcHeightAndSize(tree.ImmutableTree)
 		newNode = tree.balance(newNode, orphans)
@@ -230,7 +470,7 @@ func (tree *MutableTree) recursiveRemove(node *Node, key []byte, orphans *[]*Nod
 		return node.leftHash, node.leftNode, nil, value
 	}
 
-	newNode := node.clone(version)
+	newNode := tree.mutableNode(node)
 	newNode.rightHash, newNode.rightNode = newRightHash, newRightNode
 	if newKey != nil {
 		newNode.key = newKey
@@ -253,6 +493,12 @@ func (tree *MutableTree) Load() (int64, error) {
 // performs a no-op. Otherwise, if the root does not exist, an error will be
 // returned.
 func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
+	if err := tree.ndb.checkMetadata(); err != nil {
+		return 0, err
+	}
+	if err := tree.ndb.rollbackPending(); err != nil {
+		return 0, err
+	}
 	latestVersion := tree.ndb.getLatestVersion()
 	if latestVersion < targetVersion {
 		return latestVersion, fmt.Errorf("wanted to load target %d but only found up to %d", targetVersion, latestVersion)
@@ -273,6 +519,7 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 		return latestVersion, ErrVersionDoesNotExist
 	}
 
+	tree.discardMemVersions()
 	tree.versions[targetVersion] = true
 
 	iTree := &ImmutableTree{
@@ -282,14 +529,24 @@ func (tree *MutableTree) LazyLoadVersion(targetVersion int64) (int64, error) {
 	}
 
 	tree.orphans = map[string]int64{}
+	tree.unsavedFastNodes = map[string]*fastNode{}
+	tree.unsavedChanges = nil
 	tree.ImmutableTree = iTree
 	tree.lastSaved = iTree.clone()
+	tree.loadFastIndex()
+	tree.markFastIndex()
 
 	return targetVersion, nil
 }
 
 // Returns the version number of the latest version found
 func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
+	if err := tree.ndb.checkMetadata(); err != nil {
+		return 0, err
+	}
+	if err := tree.ndb.rollbackPending(); err != nil {
+		return 0, err
+	}
 	roots, err := tree.ndb.getRoots()
 	if err != nil {
 		return 0, err
@@ -299,6 +556,7 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 		return 0, nil
 	}
 
+	tree.discardMemVersions()
 	latestVersion := int64(0)
 
 	var latestRoot []byte
@@ -325,8 +583,12 @@ func (tree *MutableTree) LoadVersion(targetVersion int64) (int64, error) {
 	}
 
 	tree.orphans = map[string]int64{}
+	tree.unsavedFastNodes = map[string]*fastNode{}
+	tree.unsavedChanges = nil
 	tree.ImmutableTree = t
 	tree.lastSaved = t.clone()
+	tree.loadFastIndex()
+	tree.markFastIndex()
 
 	return latestVersion, nil
 }
@@ -338,25 +600,47 @@ func (tree *MutableTree) LoadVersionForOverwriting(targetVersion int64) (int64,
 	if err != nil {
 		return latestVersion, err
 	}
+	// The fast node index moves back to the loaded version, while the version
+	// it is current with is still on disk.
+	if tree.ndb.fastIndex {
+		tree.updateFastIndex()
+		tree.markFastIndex()
+	}
 	tree.deleteVersionsFrom(targetVersion + 1) // nolint:errcheck
 	return targetVersion, nil
 }
 
-// GetImmutable loads an ImmutableTree at a given version for querying
+// GetImmutable loads an ImmutableTree at a given version for querying.
+//
+// GetImmutable and the returned tree are safe to use from any number of
+// goroutines, concurrently with a goroutine that modifies and saves the
+// MutableTree, as long as the version is not deleted while it is read. In
+// KeepInMemory mode, this only holds for versions that have been flushed to
+// disk, as flushing modifies the in-memory nodes.
 func (tree *MutableTree) GetImmutable(version int64) (*ImmutableTree, error) {
+	tree.memMtx.RLock()
+	t, ok := tree.memVersions[version]
+	tree.memMtx.RUnlock()
+	if ok {
+		t = t.clone()
+		t.detached = true
+		return t, nil
+	}
 	rootHash := tree.ndb.getRoot(version)
 	if rootHash == nil {
 		return nil, ErrVersionDoesNotExist
 	} else if len(rootHash) == 0 {
 		return &ImmutableTree{
-			ndb:     tree.ndb,
-			version: version,
+			ndb:      tree.ndb,
+			version:  version,
+			detached: true,
 		}, nil
 	}
 	return &ImmutableTree{
-		root:    tree.ndb.GetNode(rootHash),
-		ndb:     tree.ndb,
-		version: version,
+		root:     tree.ndb.GetNode(rootHash),
+		ndb:      tree.ndb,
+		version:  version,
+		detached: true,
 	}, nil
 }
 
@@ -369,6 +653,8 @@ func (tree *MutableTree) Rollback() {
 		tree.ImmutableTree = &ImmutableTree{ndb: tree.ndb, version: 0}
 	}
 	tree.orphans = map[string]int64{}
+	tree.unsavedFastNodes = map[string]*fastNode{}
+	tree.unsavedChanges = nil
 }
 
 // GetVersioned gets the value at the specified key and version.
@@ -380,14 +666,16 @@ func (tree *MutableTree) GetVersioned(key []byte, version int64) (
 		if err != nil {
 			return -1, nil
 		}
-		return t.Get(key)
+		return t.GetWithIndex(key)
 	}
 	return -1, nil
 }
 
 // SaveVersion saves a new tree version to disk, based on the current state of
-// the tree. Returns the hash and new version number.
+// the tree. Returns the hash and new version number. Versions outside of the
+// tree's pruning options are deleted afterwards.
 func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
+	defer func(start time.Time) { tree.ndb.metrics.SaveVersion(time.Since(start)) }(time.Now())
 	version := tree.version + 1
 
 	if tree.versions[version] {
@@ -400,26 +688,37 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 			tree.ImmutableTree = tree.ImmutableTree.clone()
 			tree.lastSaved = tree.ImmutableTree.clone()
 			tree.orphans = map[string]int64{}
+			tree.unsavedFastNodes = map[string]*fastNode{}
+			tree.markFastIndex()
+			tree.notifyCommit(version, existingHash)
 			return existingHash, version, nil
 		}
 		return nil, version, fmt.Errorf("version %d was already saved to different hash %X (existing hash %X)",
 			version, newHash, existingHash)
 	}
 
+	if tree.opts.KeepInMemory {
+		return tree.saveVersionInMemory(version)
+	}
+
+	var fastIndexed bool
 	if tree.root == nil {
 		// There can still be orphans, for example if the root is the node being
 		// removed.
-		debug("SAVE EMPTY TREE %v\n", version)
+		tree.ndb.logger.Debug("save empty version", "version", version, "orphans", len(tree.orphans))
 		tree.ndb.SaveOrphans(version, tree.orphans)
+		fastIndexed = tree.saveFastNodes(version, nil, tree.unsavedFastNodes)
 		err := tree.ndb.SaveEmptyRoot(version)
 		if err != nil {
 			panic(err)
 		}
 	} else {
-		debug("SAVE TREE %v\n", version)
-		// Save the current tree.
+		tree.ndb.logger.Debug("save version", "version", version, "orphans", len(tree.orphans))
+		// Save the current tree, after hashing it concurrently if configured.
+		tree.WorkingHash()
 		tree.ndb.SaveBranch(tree.root)
 		tree.ndb.SaveOrphans(version, tree.orphans)
+		fastIndexed = tree.saveFastNodes(version, tree.root.hash, tree.unsavedFastNodes)
 		err := tree.ndb.SaveRoot(tree.root, version)
 		if err != nil {
 			panic(err)
@@ -433,13 +732,222 @@ func (tree *MutableTree) SaveVersion() ([]byte, int64, error) {
 	tree.ImmutableTree = tree.ImmutableTree.clone()
 	tree.lastSaved = tree.ImmutableTree.clone()
 	tree.orphans = map[string]int64{}
+	tree.unsavedFastNodes = map[string]*fastNode{}
+	tree.notifyCommit(version, tree.Hash())
+	tree.ndb.metrics.TreeShape(tree.Height(), tree.Size())
+
+	if !fastIndexed {
+		tree.ndb.rebuildFastIndex(tree.lastSaved)
+	}
+	tree.markFastIndex()
+
+	if tree.opts.Pruning.shouldPrune(version) {
+		if err := tree.prune(version); err != nil {
+			return tree.Hash(), version, errors.Wrap(err, "pruning")
+		}
+	}
+
+	return tree.Hash(), version, nil
+}
+
+// saveVersionInMemory saves a new version in memory only. Every
+// FlushInterval versions the new version is flushed to disk.
+func (tree *MutableTree) saveVersionInMemory(version int64) ([]byte, int64, error) {
+	tree.WorkingHash() // Ensure that all hashes are calculated.
+
+	for hash, fromVersion := range tree.orphans {
+		tree.unflushedOrphans[hash] = fromVersion
+	}
+	for key, fastNode := range tree.unsavedFastNodes {
+		tree.unflushedFastNodes[key] = fastNode
+	}
+	tree.version = version
+	tree.versions[version] = true
+
+	// Set new working tree.
+	tree.ImmutableTree = tree.ImmutableTree.clone()
+	tree.lastSaved = tree.ImmutableTree.clone()
+	tree.memMtx.Lock()
+	tree.memVersions[version] = tree.ImmutableTree.clone()
+	tree.memMtx.Unlock()
+	tree.orphans = map[string]int64{}
+	tree.unsavedFastNodes = map[string]*fastNode{}
+	tree.notifyCommit(version, tree.Hash())
+	tree.ndb.metrics.TreeShape(tree.Height(), tree.Size())
+
+	interval := tree.opts.FlushInterval
+	if interval <= 1 || version%interval == 0 {
+		if err := tree.Flush(); err != nil {
+			return tree.Hash(), version, errors.Wrap(err, "flushing")
+		}
+		return tree.Hash(), version, nil
+	}
+
+	// Versions in memory are pruned like those on disk.
+	tree.memMtx.Lock()
+	for v := range tree.memVersions {
+		if !tree.opts.Pruning.keep(v, version) {
+			delete(tree.memVersions, v)
+			delete(tree.versions, v)
+		}
+	}
+	tree.memMtx.Unlock()
 
 	return tree.Hash(), version, nil
 }
 
+// Flush writes the latest saved version to disk if it only exists in memory,
+// and discards all other versions that only exist in memory. Versions that
+// have not been flushed are lost on restart. If the tree does not keep
+// versions in memory, all versions are already on disk and Flush is a no-op.
+func (tree *MutableTree) Flush() error {
+	version := tree.version
+	if _, ok := tree.memVersions[version]; !ok {
+		return nil
+	}
+
+	var fastIndexed bool
+	root := tree.lastSaved.root
+	if root == nil {
+		tree.ndb.logger.Debug("flush empty version", "version", version, "orphans", len(tree.unflushedOrphans))
+		tree.ndb.SaveOrphans(version, tree.unflushedOrphans)
+		fastIndexed = tree.saveFastNodes(version, nil, tree.unflushedFastNodes)
+		// Versions that were only kept in memory leave gaps on disk.
+		if err := tree.ndb.saveRoot([]byte{}, version, true); err != nil {
+			return err
+		}
+	} else {
+		tree.ndb.logger.Debug("flush version", "version", version, "orphans", len(tree.unflushedOrphans))
+		tree.ndb.SaveBranch(root)
+		tree.ndb.SaveOrphans(version, tree.unflushedOrphans)
+		fastIndexed = tree.saveFastNodes(version, root.hash, tree.unflushedFastNodes)
+		if err := tree.ndb.saveRoot(root.hash, version, true); err != nil {
+			return err
+		}
+	}
+	tree.ndb.Commit()
+	tree.unflushedFastNodes = map[string]*fastNode{}
+	if !fastIndexed {
+		tree.ndb.rebuildFastIndex(tree.lastSaved)
+	}
+	tree.markFastIndex()
+
+	// In-memory versions may refer to nodes that are orphaned between the
+	// previous and the flushed version, which pruning deletes from disk.
+	for v := range tree.memVersions {
+		if v != version {
+			delete(tree.versions, v)
+		}
+	}
+	tree.memMtx.Lock()
+	tree.memVersions = map[int64]*ImmutableTree{}
+	tree.memMtx.Unlock()
+	tree.unflushedOrphans = map[string]int64{}
+
+	if tree.opts.Pruning.KeepRecent > 0 {
+		return tree.prune(version)
+	}
+	return nil
+}
+
+// discardMemVersions forgets all versions that have not been flushed to disk.
+func (tree *MutableTree) discardMemVersions() {
+	for v := range tree.memVersions {
+		delete(tree.versions, v)
+	}
+	tree.memMtx.Lock()
+	tree.memVersions = map[int64]*ImmutableTree{}
+	tree.memMtx.Unlock()
+	tree.unflushedOrphans = map[string]int64{}
+	tree.unflushedFastNodes = map[string]*fastNode{}
+}
+
+// saveFastNodes adds the fast node changes since the latest version on disk to
+// the batch saving version. It must be called before the version's root is
+// saved. If the fast node index is not current with the latest version on
+// disk, nothing is written and false is returned; the index then has to be
+// rebuilt once the version is committed.
+func (tree *MutableTree) saveFastNodes(version int64, rootHash []byte, changes map[string]*fastNode) bool {
+	if !tree.ndb.fastIndex {
+		return true
+	}
+	if !tree.ndb.hasFastIndex(tree.ndb.getRoot(tree.ndb.getLatestVersion())) {
+		return false
+	}
+	for key, fastNode := range changes {
+		if fastNode == nil {
+			tree.ndb.deleteFastNode([]byte(key))
+		} else {
+			tree.ndb.saveFastNode(fastNode)
+		}
+	}
+	tree.ndb.saveFastIndexState(version, rootHash)
+	return true
+}
+
+// markFastIndex records in the working tree and the last saved version
+// whether the fast node index is current with them.
+func (tree *MutableTree) markFastIndex() {
+	tree.ImmutableTree.markFastIndex()
+	tree.lastSaved.markFastIndex()
+}
+
+// loadFastIndex brings the fast node index up to date with the last saved
+// version, if it is enabled and the version is the latest one on disk. Older
+// versions are read from the tree, so loading one leaves the index alone.
+func (tree *MutableTree) loadFastIndex() {
+	if tree.ndb.fastIndex && tree.version >= tree.ndb.getLatestVersion() {
+		tree.updateFastIndex()
+	}
+}
+
+// updateFastIndex makes the fast node index current with the last saved
+// version. If the version the index is current with is still on disk, only
+// the keys that changed are written. Otherwise, such as for databases written
+// without the index, it is rebuilt.
+func (tree *MutableTree) updateFastIndex() {
+	if tree.ndb.hasFastIndex(tree.lastSaved.Hash()) {
+		return
+	}
+	version, rootHash := tree.ndb.getFastIndexState()
+	if version > 0 {
+		if from, err := tree.GetImmutable(version); err == nil && bytes.Equal(from.Hash(), rootHash) {
+			tree.ndb.updateFastIndex(from, tree.lastSaved)
+			return
+		}
+	}
+	tree.ndb.rebuildFastIndex(tree.lastSaved)
+}
+
+// prune deletes all saved versions that fall outside of the pruning options,
+// given that latest is the latest saved version. Consecutive pruned versions
+// are deleted as a single range.
+func (tree *MutableTree) prune(latest int64) error {
+	from := int64(0)
+	for _, v := range tree.AvailableVersions() {
+		version := int64(v)
+		if !tree.opts.Pruning.keep(version, latest) {
+			if from == 0 {
+				from = version
+			}
+			continue
+		}
+		if from > 0 {
+			tree.ndb.logger.Info("pruning versions", "from", from, "to", version, "latest", latest)
+			if err := tree.DeleteVersionsRange(from, version); err != nil {
+				tree.ndb.logger.Error("failed to prune versions", "from", from, "to", version, "err", err)
+				return err
+			}
+			from = 0
+		}
+	}
+	return nil
+}
+
 // DeleteVersion deletes a tree version from disk. The version can then no
 // longer be accessed.
 func (tree *MutableTree) DeleteVersion(version int64) error {
+	defer func(start time.Time) { tree.ndb.metrics.DeleteVersion(time.Since(start)) }(time.Now())
 	if version == 0 {
 		return errors.New("version must be greater than 0")
 	}
@@ -449,7 +957,18 @@ func (tree *MutableTree) DeleteVersion(version int64) error {
 	if _, ok := tree.versions[version]; !ok {
 		return errors.Wrap(ErrVersionDoesNotExist, "")
 	}
+	if _, ok := tree.memVersions[version]; ok {
+		tree.memMtx.Lock()
+		delete(tree.memVersions, version)
+		tree.memMtx.Unlock()
+		delete(tree.versions, version)
+		return nil
+	}
+	if version == tree.ndb.getLatestVersion() {
+		return errors.Errorf("cannot delete latest flushed version (%d) while later versions are only in memory", version)
+	}
 
+	tree.ndb.logger.Debug("delete version", "version", version)
 	tree.ndb.DeleteVersion(version, true)
 	tree.ndb.Commit()
 
@@ -458,37 +977,68 @@ func (tree *MutableTree) DeleteVersion(version int64) error {
 	return nil
 }
 
-// deleteVersionsFrom deletes tree version from disk specified version to latest version. The version can then no
-// longer be accessed.
+// DeleteVersionsRange deletes all saved versions in [fromVersion, toVersion)
+// from disk in a single batch. The result is the same as deleting each of
+// them with DeleteVersion, but the orphans are traversed only once.
+func (tree *MutableTree) DeleteVersionsRange(fromVersion, toVersion int64) error {
+	defer func(start time.Time) { tree.ndb.metrics.DeleteVersion(time.Since(start)) }(time.Now())
+	if fromVersion <= 0 {
+		return errors.New("version must be greater than 0")
+	}
+	if fromVersion >= toVersion {
+		return errors.Errorf("invalid version range [%d, %d)", fromVersion, toVersion)
+	}
+	if toVersion > tree.version {
+		return errors.Errorf("cannot delete latest saved version (%d)", tree.version)
+	}
+	if latest := tree.ndb.getLatestVersion(); fromVersion <= latest && latest < toVersion {
+		return errors.Errorf("cannot delete latest flushed version (%d) while later versions are only in memory", latest)
+	}
+
+	tree.ndb.logger.Debug("delete versions", "from", fromVersion, "to", toVersion)
+	tree.ndb.DeleteVersionsRange(fromVersion, toVersion)
+	tree.ndb.Commit()
+
+	tree.memMtx.Lock()
+	for version := range tree.versions {
+		if version >= fromVersion && version < toVersion {
+			delete(tree.versions, version)
+			delete(tree.memVersions, version)
+		}
+	}
+	tree.memMtx.Unlock()
+
+	return nil
+}
+
+// deleteVersionsFrom deletes all tree versions from disk from the specified
+// version to the latest version in a single batch, so that a crash either
+// deletes all of them or none. The versions can then no longer be accessed.
 func (tree *MutableTree) deleteVersionsFrom(version int64) error {
 	if version <= 0 {
 		return errors.New("version must be greater than 0")
 	}
-	newLatestVersion := version - 1
-	lastestVersion := tree.ndb.getLatestVersion()
-	for ; version <= lastestVersion; version++ {
-		if version == tree.version {
-			return errors.Errorf("cannot delete latest saved version (%d)", version)
-		}
-		if _, ok := tree.versions[version]; !ok {
-			return errors.Wrap(ErrVersionDoesNotExist, "")
-		}
-		tree.ndb.DeleteVersion(version, false)
-		delete(tree.versions, version)
+	if version <= tree.version {
+		return errors.Errorf("cannot delete latest saved version (%d)", tree.version)
 	}
+	tree.ndb.logger.Debug("delete versions", "from", version)
+	tree.ndb.DeleteVersionsFrom(version)
 	tree.ndb.Commit()
-	tree.ndb.resetLatestVersion(newLatestVersion)
+	tree.ndb.resetLatestVersion(version - 1)
+	for v := range tree.versions {
+		if v >= version {
+			delete(tree.versions, v)
+		}
+	}
 	return nil
 }
 
 // Rotate right and return the new node and orphan.
 func (tree *MutableTree) rotateRight(node *Node) (*Node, *Node) {
-	version := tree.version + 1
-
 	// TODO: optimize balance & rotate.
-	node = node.clone(version)
+	node = tree.mutableNode(node)
 	orphaned := node.getLeftNode(tree.ImmutableTree)
-	newNode := orphaned.clone(version)
+	newNode := tree.mutableNode(orphaned)
 
 	newNoderHash, newNoderCached := newNode.rightHash, newNode.rightNode
 	newNode.rightHash, newNode.rightNode = node.hash, node
@@ -502,12 +1052,10 @@ func (tree *MutableTree) rotateRight(node *Node) (*Node, *Node) {
 
 // Rotate left and return the new node and orphan.
 func (tree *MutableTree) rotateLeft(node *Node) (*Node, *Node) {
-	version := tree.version + 1
-
 	// TODO: optimize balance & rotate.
-	node = node.clone(version)
+	node = tree.mutableNode(node)
 	orphaned := node.getRightNode(tree.ImmutableTree)
-	newNode := orphaned.clone(version)
+	newNode := tree.mutableNode(orphaned)
 
 	newNodelHash, newNodelCached := newNode.leftHash, newNode.leftNode
 	newNode.leftHash, newNode.leftNode = node.hash, node
@@ -566,6 +1114,26 @@ func (tree *MutableTree) balance(node *Node, orphans *[]*Node) (newSelf *Node) {
 	return node
 }
 
+// mutableNode returns a copy of the inner node for the working tree to modify.
+// Nodes created by the running batch are not referenced by any other tree,
+// so they are modified in place instead of being copied again.
+func (tree *MutableTree) mutableNode(node *Node) *Node {
+	if tree.batchNodes != nil {
+		if _, ok := tree.batchNodes[node]; ok {
+			return node
+		}
+	}
+	return tree.addBatchNode(node.clone(tree.version + 1))
+}
+
+// addBatchNode registers an inner node created by the running batch, if any.
+func (tree *MutableTree) addBatchNode(node *Node) *Node {
+	if tree.batchNodes != nil {
+		tree.batchNodes[node] = struct{}{}
+	}
+	return node
+}
+
 func (tree *MutableTree) addOrphans(orphans []*Node) {
 	for _, node := range orphans {
 		if !node.persisted {
@@ -1,12 +1,76 @@
 package iavl
 
 import (
+	"bytes"
+	"fmt"
+	mrand "math/rand"
 	"runtime"
+	"sort"
+	"sync"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	db "github.com/tendermint/tm-db"
 )
 
+func TestPruningOptions(t *testing.T) {
+	testCases := map[string]struct {
+		opts     PruningOptions
+		versions int64
+		expected []int
+	}{
+		"nothing":      {PruneNothing(), 5, []int{1, 2, 3, 4, 5}},
+		"everything":   {PruneEverything(), 5, []int{5}},
+		"keep recent":  {NewPruningOptions(3, 0, 0), 10, []int{8, 9, 10}},
+		"keep every":   {NewPruningOptions(2, 4, 0), 10, []int{4, 8, 9, 10}},
+		"interval":     {NewPruningOptions(2, 0, 4), 10, []int{7, 8, 9, 10}},
+		"interval hit": {NewPruningOptions(2, 5, 4), 12, []int{5, 10, 11, 12}},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Pruning: tc.opts})
+			for v := int64(1); v <= tc.versions; v++ {
+				tree.Set([]byte("k"), i2b(int(v)))
+				tree.Set(i2b(int(v)), []byte("v"))
+				_, _, err := tree.SaveVersion()
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expected, tree.AvailableVersions())
+
+			// Pruned versions must also be gone from disk.
+			reloaded := NewMutableTree(tree.ndb.db, 0)
+			_, err := reloaded.Load()
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, reloaded.AvailableVersions())
+			for _, v := range tc.expected {
+				_, value := reloaded.GetVersioned([]byte("k"), int64(v))
+				require.Equal(t, i2b(v), value)
+			}
+		})
+	}
+}
+
+func TestPruningMatchesDeleteVersion(t *testing.T) {
+	pruned := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Pruning: NewPruningOptions(3, 0, 0)})
+	manual := NewMutableTree(db.NewMemDB(), 0)
+	for v := int64(1); v <= 20; v++ {
+		for _, tree := range []*MutableTree{pruned, manual} {
+			tree.Set(i2b(int(v%7)), i2b(int(v)))
+			tree.Remove(i2b(int(v % 5)))
+			_, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+		}
+		if v > 3 {
+			require.NoError(t, manual.DeleteVersion(v-3))
+		}
+	}
+	require.Equal(t, manual.ndb.size(), pruned.ndb.size())
+	require.Equal(t, manual.ndb.orphans(), pruned.ndb.orphans())
+	require.Equal(t, manual.Hash(), pruned.Hash())
+}
+
 func BenchmarkMutableTree_Set(b *testing.B) {
 	db := db.NewDB("test", db.MemDBBackend, "")
 	t := NewMutableTree(db, 100000)
@@ -22,3 +86,334 @@ func BenchmarkMutableTree_Set(b *testing.B) {
 		t.Set(randBytes(10), []byte{})
 	}
 }
+
+func TestDeleteVersionsRange(t *testing.T) {
+	ranged := NewMutableTree(db.NewMemDB(), 0)
+	single := NewMutableTree(db.NewMemDB(), 0)
+	for v := 1; v <= 30; v++ {
+		for _, tree := range []*MutableTree{ranged, single} {
+			for i := 0; i < 10; i++ {
+				tree.Set(i2b((v*7+i*13)%50), i2b(v))
+			}
+			tree.Remove(i2b(v % 50))
+			_, _, err := tree.SaveVersion()
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, single.DeleteVersion(3))
+	require.NoError(t, ranged.DeleteVersion(3))
+
+	require.Error(t, ranged.DeleteVersionsRange(0, 5))
+	require.Error(t, ranged.DeleteVersionsRange(5, 5))
+	require.Error(t, ranged.DeleteVersionsRange(20, 31))
+
+	require.NoError(t, ranged.DeleteVersionsRange(2, 25))
+	for v := int64(2); v < 25; v++ {
+		if v != 3 {
+			require.NoError(t, single.DeleteVersion(v))
+		}
+	}
+
+	require.Equal(t, single.AvailableVersions(), ranged.AvailableVersions())
+	singleDB, rangedDB := map[string]string{}, map[string]string{}
+	single.ndb.traverse(func(k, v []byte) { singleDB[string(k)] = string(v) })
+	ranged.ndb.traverse(func(k, v []byte) { rangedDB[string(k)] = string(v) })
+	require.Equal(t, singleDB, rangedDB)
+
+	for _, v := range ranged.AvailableVersions() {
+		_, err := ranged.GetImmutable(int64(v))
+		require.NoError(t, err)
+		_, value := ranged.GetVersioned(i2b(10), int64(v))
+		_, expected := single.GetVersioned(i2b(10), int64(v))
+		require.Equal(t, expected, value)
+	}
+}
+
+func TestKeepInMemory(t *testing.T) {
+	memDB := db.NewMemDB()
+	opts := &Options{Pruning: NewPruningOptions(3, 0, 0), KeepInMemory: true, FlushInterval: 5}
+	tree := NewMutableTreeWithOpts(memDB, 0, opts)
+	reference := NewMutableTree(db.NewMemDB(), 0)
+
+	hashes := map[int64][]byte{}
+	for v := int64(1); v <= 12; v++ {
+		for _, tr := range []*MutableTree{tree, reference} {
+			tr.Set(i2b(int(v%4)), i2b(int(v)))
+			tr.Set(i2b(int(v)), []byte("v"))
+			tr.Remove(i2b(int(v - 2)))
+		}
+		hash, version, err := tree.SaveVersion()
+		require.NoError(t, err)
+		refHash, _, err := reference.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, v, version)
+		require.Equal(t, refHash, hash)
+		hashes[v] = hash
+	}
+
+	// Only flushed versions are on disk, and only 10 is a recent one.
+	require.Equal(t, []int{10, 11, 12}, tree.AvailableVersions())
+	require.Equal(t, []int64{10}, func() (vs []int64) {
+		for v := range tree.ndb.roots() {
+			vs = append(vs, v)
+		}
+		return vs
+	}())
+	for _, v := range []int64{10, 11, 12} {
+		_, value := tree.GetVersioned(i2b(int(v)), v)
+		require.Equal(t, []byte("v"), value)
+		itree, err := tree.GetImmutable(v)
+		require.NoError(t, err)
+		require.Equal(t, hashes[v], itree.Hash())
+	}
+
+	// Nothing unflushed survives a restart.
+	reloaded := NewMutableTreeWithOpts(memDB, 0, opts)
+	version, err := reloaded.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(10), version)
+	require.Equal(t, hashes[10], reloaded.Hash())
+
+	// An explicit flush persists the latest version.
+	require.NoError(t, tree.Flush())
+	require.Equal(t, []int{10, 12}, tree.AvailableVersions())
+	reloaded = NewMutableTreeWithOpts(memDB, 0, opts)
+	version, err = reloaded.Load()
+	require.NoError(t, err)
+	require.Equal(t, int64(12), version)
+	require.Equal(t, hashes[12], reloaded.Hash())
+
+	// Pruning only ever writes the flushed versions' nodes to disk.
+	for v := int64(13); v <= 20; v++ {
+		tree.Set(i2b(int(v)), []byte("v"))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{20}, tree.AvailableVersions())
+	require.Equal(t, tree.nodeSize(), len(tree.ndb.nodes()))
+	require.Empty(t, tree.ndb.orphans())
+}
+
+func TestKeepInMemoryDeleteFlushedVersion(t *testing.T) {
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{KeepInMemory: true, FlushInterval: 2})
+	for v := 1; v <= 5; v++ {
+		tree.Set(i2b(v), i2b(v))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+
+	// Version 4 is the latest flushed version, but version 5 is only in
+	// memory.
+	require.Equal(t, []int{2, 4, 5}, tree.AvailableVersions())
+	require.Error(t, tree.DeleteVersion(4))
+	require.Error(t, tree.DeleteVersionsRange(2, 5))
+	require.Equal(t, []int{2, 4, 5}, tree.AvailableVersions())
+	require.NoError(t, tree.DeleteVersion(2))
+
+	require.NoError(t, tree.Flush())
+	require.NoError(t, tree.DeleteVersion(4))
+	require.Equal(t, []int{5}, tree.AvailableVersions())
+}
+
+func TestKeepInMemoryKeepEvery(t *testing.T) {
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Pruning: NewPruningOptions(2, 3, 0), KeepInMemory: true, FlushInterval: 10})
+	for v := 1; v <= 8; v++ {
+		tree.Set(i2b(v), i2b(v))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	require.Equal(t, []int{3, 6, 7, 8}, tree.AvailableVersions())
+}
+
+func TestSaveRootConsecutive(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	tree.Set([]byte("a"), []byte("1"))
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	// Only versions flushed from memory may skip versions.
+	require.Error(t, tree.ndb.SaveEmptyRoot(3))
+	require.Error(t, tree.ndb.saveRoot([]byte{}, 1, true))
+	require.NoError(t, tree.ndb.saveRoot([]byte{}, 3, true))
+	require.NoError(t, tree.ndb.SaveEmptyRoot(4))
+}
+
+// randBatch returns sorted ops on a third of 1000 keys, which remove a third
+// of them and set the others.
+func randBatch() []BatchOp {
+	ops := []BatchOp{}
+	for i := 0; i < 1000; i++ {
+		switch mrand.Intn(9) {
+		case 0, 1:
+			ops = append(ops, BatchOp{Key: indexKey(i), Value: randBytes(1)})
+		case 2:
+			ops = append(ops, BatchOp{Key: indexKey(i), Delete: true})
+		}
+	}
+	return ops
+}
+
+func TestApplyBatch(t *testing.T) {
+	for _, opts := range []*Options{
+		DefaultOptions(),
+		{KeepInMemory: true, FlushInterval: 3, Pruning: NewPruningOptions(2, 0, 0)},
+		{FastIndex: true},
+	} {
+		sequential := NewMutableTreeWithOpts(db.NewMemDB(), 0, opts)
+		batched := NewMutableTreeWithOpts(db.NewMemDB(), 0, opts)
+		sequentialListener, batchedListener := &recordingListener{}, &recordingListener{}
+		sequential.AddListener(sequentialListener)
+		batched.AddListener(batchedListener)
+
+		for v := 0; v < 10; v++ {
+			for i := 0; i < 3; i++ {
+				ops := randBatch()
+				for _, op := range ops {
+					if op.Delete {
+						sequential.Remove(op.Key)
+					} else {
+						sequential.Set(op.Key, op.Value)
+					}
+				}
+				require.NoError(t, batched.ApplyBatch(ops))
+				require.Equal(t, sequential.WorkingHash(), batched.WorkingHash())
+			}
+
+			sequentialHash, _, err := sequential.SaveVersion()
+			require.NoError(t, err)
+			batchedHash, _, err := batched.SaveVersion()
+			require.NoError(t, err)
+			require.Equal(t, sequentialHash, batchedHash)
+		}
+		require.Equal(t, sequentialListener.writes, batchedListener.writes)
+
+		// Same nodes and orphans are written to disk.
+		require.NoError(t, sequential.Flush())
+		require.NoError(t, batched.Flush())
+		sequentialDB, batchedDB := map[string]string{}, map[string]string{}
+		sequential.ndb.traverse(func(k, v []byte) { sequentialDB[string(k)] = string(v) })
+		batched.ndb.traverse(func(k, v []byte) { batchedDB[string(k)] = string(v) })
+		require.Equal(t, sequentialDB, batchedDB)
+	}
+}
+
+func TestApplyBatchEmpty(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	require.NoError(t, tree.ApplyBatch([]BatchOp{
+		{Key: []byte{1}, Delete: true},
+		{Key: []byte{2}, Value: []byte{2}},
+		{Key: []byte{3}, Value: []byte{3}},
+	}))
+	require.EqualValues(t, 2, tree.Size())
+
+	// Removing all keys empties the tree.
+	require.NoError(t, tree.ApplyBatch([]BatchOp{
+		{Key: []byte{2}, Delete: true},
+		{Key: []byte{3}, Delete: true},
+		{Key: []byte{4}, Delete: true},
+	}))
+	require.True(t, tree.IsEmpty())
+}
+
+func TestApplyBatchInvalid(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	require.Error(t, tree.ApplyBatch([]BatchOp{{Key: []byte{2}, Value: []byte{2}}, {Key: []byte{1}, Value: []byte{1}}}))
+	require.Error(t, tree.ApplyBatch([]BatchOp{{Key: []byte{1}, Value: []byte{1}}, {Key: []byte{1}, Delete: true}}))
+	require.Error(t, tree.ApplyBatch([]BatchOp{{Key: []byte{1}, Value: []byte{1}}, {Key: []byte{2}}}))
+	// Nothing is applied on errors.
+	require.True(t, tree.IsEmpty())
+}
+
+func BenchmarkMutableTree_ApplyBatch(b *testing.B) {
+	t := NewMutableTree(db.NewMemDB(), 100000)
+	for i := 0; i < 100000; i++ {
+		t.Set(randBytes(10), []byte{})
+	}
+	_, _, err := t.SaveVersion()
+	require.NoError(b, err)
+	ops := make([]BatchOp, 10000)
+	for i := range ops {
+		ops[i] = BatchOp{Key: randBytes(10), Value: []byte{}}
+	}
+	sort.Slice(ops, func(i, j int) bool { return bytes.Compare(ops[i].Key, ops[j].Key) < 0 })
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		require.NoError(b, t.ApplyBatch(ops))
+		t.Rollback()
+	}
+}
+
+// TestConcurrentReaders should be run with the race detector.
+func TestConcurrentReaders(t *testing.T) {
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 100, &Options{FastIndex: true, HashWorkers: 4})
+	// Contents of the versions read concurrently, which are written first.
+	contents := map[int64]map[string]string{}
+	latest := map[string]string{}
+	for version := int64(1); version <= 10; version++ {
+		for i := 0; i < 50; i++ {
+			key, value := randBytes(2), randBytes(4)
+			tree.Set(key, value)
+			latest[string(key)] = string(value)
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		contents[version] = map[string]string{}
+		for k, v := range latest {
+			contents[version][k] = v
+		}
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, 8)
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				version := int64((r+i)%10 + 1)
+				itree, err := tree.GetImmutable(version)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				read := map[string]string{}
+				itree.Iterate(func(key, value []byte) bool {
+					read[string(key)] = string(value)
+					return false
+				})
+				if !assert.ObjectsAreEqual(contents[version], read) {
+					errCh <- fmt.Errorf("version %v has unexpected contents", version)
+					return
+				}
+				for key, value := range read {
+					proofValue, proof, err := itree.GetWithProof([]byte(key))
+					if err == nil {
+						err = proof.Verify(itree.Hash())
+					}
+					if err != nil {
+						errCh <- err
+						return
+					}
+					if string(proofValue) != value {
+						errCh <- fmt.Errorf("unexpected value %X for key %X", proofValue, key)
+						return
+					}
+				}
+			}
+		}(r)
+	}
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 50; j++ {
+			tree.Set(randBytes(2), randBytes(4))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		require.NoError(t, err)
+	}
+}
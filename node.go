@@ -7,11 +7,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/pkg/errors"
 
 	amino "github.com/tendermint/go-amino"
-	"github.com/tendermint/tendermint/crypto/tmhash"
 )
 
 // Node represents a node in a Tree.
@@ -195,14 +195,14 @@ func (node *Node) getByIndex(t *ImmutableTree, index int64) (key []byte, value [
 
 // Computes the hash of the node without computing its descendants. Must be
 // called on nodes which have descendant node hashes already computed.
-func (node *Node) _hash() []byte {
+func (node *Node) _hash(hasher Hasher) []byte {
 	if node.hash != nil {
 		return node.hash
 	}
 
-	h := tmhash.New()
+	h := hasher.New()
 	buf := new(bytes.Buffer)
-	if err := node.writeHashBytes(buf); err != nil {
+	if err := node.writeHashBytes(buf, hasher); err != nil {
 		panic(err)
 	}
 	_, err := h.Write(buf.Bytes())
@@ -216,14 +216,14 @@ func (node *Node) _hash() []byte {
 
 // Hash the node and its descendants recursively. This usually mutates all
 // descendant nodes. Returns the node hash and number of nodes hashed.
-func (node *Node) hashWithCount() ([]byte, int64) {
+func (node *Node) hashWithCount(hasher Hasher) ([]byte, int64) {
 	if node.hash != nil {
 		return node.hash, 0
 	}
 
-	h := tmhash.New()
+	h := hasher.New()
 	buf := new(bytes.Buffer)
-	hashCount, err := node.writeHashBytesRecursively(buf)
+	hashCount, err := node.writeHashBytesRecursively(buf, hasher)
 	if err != nil {
 		panic(err)
 	}
@@ -236,9 +236,47 @@ func (node *Node) hashWithCount() ([]byte, int64) {
 	return node.hash, hashCount + 1
 }
 
+// parallelHashMinHeight is the minimum height of a node for its subtrees to
+// be hashed concurrently. Smaller subtrees are not worth a goroutine.
+const parallelHashMinHeight = 6
+
+// hashWithCountParallel is like hashWithCount, but hashes the left and right
+// subtrees of a node concurrently if a worker can be acquired from sem. Each
+// value in sem is a worker besides the calling goroutine, and a nil sem hashes
+// serially. The resulting hashes are the same as with hashWithCount.
+func (node *Node) hashWithCountParallel(hasher Hasher, sem chan struct{}) ([]byte, int64) {
+	if node.hash != nil {
+		return node.hash, 0
+	}
+	if sem == nil || node.height < parallelHashMinHeight || node.leftNode == nil || node.rightNode == nil {
+		return node.hashWithCount(hasher)
+	}
+
+	var leftHash, rightHash []byte
+	var leftCount, rightCount int64
+	select {
+	case sem <- struct{}{}:
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			leftHash, leftCount = node.leftNode.hashWithCountParallel(hasher, sem)
+			<-sem
+		}()
+		rightHash, rightCount = node.rightNode.hashWithCountParallel(hasher, sem)
+		wg.Wait()
+	default:
+		leftHash, leftCount = node.leftNode.hashWithCountParallel(hasher, sem)
+		rightHash, rightCount = node.rightNode.hashWithCountParallel(hasher, sem)
+	}
+	node.leftHash, node.rightHash = leftHash, rightHash
+
+	return node._hash(hasher), leftCount + rightCount + 1
+}
+
 // Writes the node's hash to the given io.Writer. This function expects
 // child hashes to be already set.
-func (node *Node) writeHashBytes(w io.Writer) error {
+func (node *Node) writeHashBytes(w io.Writer, hasher Hasher) error {
 	err := amino.EncodeInt8(w, node.height)
 	if err != nil {
 		return errors.Wrap(err, "writing height")
@@ -261,7 +299,7 @@ func (node *Node) writeHashBytes(w io.Writer) error {
 		}
 		// Indirection needed to provide proofs without values.
 		// (e.g. proofLeafNode.ValueHash)
-		valueHash := tmhash.Sum(node.value)
+		valueHash := hashBytes(hasher, node.value)
 		err = amino.EncodeByteSlice(w, valueHash)
 		if err != nil {
 			return errors.Wrap(err, "writing value")
@@ -285,18 +323,18 @@ func (node *Node) writeHashBytes(w io.Writer) error {
 
 // Writes the node's hash to the given io.Writer.
 // This function has the side-effect of calling hashWithCount.
-func (node *Node) writeHashBytesRecursively(w io.Writer) (hashCount int64, err error) {
+func (node *Node) writeHashBytesRecursively(w io.Writer, hasher Hasher) (hashCount int64, err error) {
 	if node.leftNode != nil {
-		leftHash, leftCount := node.leftNode.hashWithCount()
+		leftHash, leftCount := node.leftNode.hashWithCount(hasher)
 		node.leftHash = leftHash
 		hashCount += leftCount
 	}
 	if node.rightNode != nil {
-		rightHash, rightCount := node.rightNode.hashWithCount()
+		rightHash, rightCount := node.rightNode.hashWithCount(hasher)
 		node.rightHash = rightHash
 		hashCount += rightCount
 	}
-	err = node.writeHashBytes(w)
+	err = node.writeHashBytes(w, hasher)
 
 	return
 }
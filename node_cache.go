@@ -0,0 +1,364 @@
+package iavl
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// nodeCacheShards is the number of shards of a node cache. Node hashes are
+// uniformly distributed, so the first byte of the hash picks the shard.
+const nodeCacheShards = 16
+
+// DefaultCacheBytes is the memory budget of the default node cache.
+const DefaultCacheBytes = 64 << 20
+
+// cachedNodeOverhead is the approximate memory used by a cached node besides
+// its byte slices: the node itself and its list element.
+const cachedNodeOverhead = int64(unsafe.Sizeof(Node{}) + unsafe.Sizeof(list.Element{}))
+
+// Cache caches the nodes of a tree by hash. Implementations must be safe for
+// concurrent use. The caches created by NewLRUCache and NewTwoQueueCache are
+// sharded so that concurrent readers rarely contend for the same lock, and
+// their budget is split evenly between the shards.
+type Cache interface {
+	// Get returns the node with the hash, or nil if it isn't cached.
+	Get(hash []byte) *Node
+	// Add caches the node under its hash, replacing any node cached under
+	// the same hash.
+	Add(hash []byte, node *Node)
+	// Remove removes the node with the hash, if it is cached.
+	Remove(hash []byte)
+	// Len returns the number of cached nodes.
+	Len() int
+	// Stats returns the counters of the cache.
+	Stats() CacheStats
+}
+
+// CacheStats are the counters of a Cache.
+type CacheStats struct {
+	Hits      uint64 // Lookups that found the node.
+	Misses    uint64 // Lookups that did not find the node.
+	Evictions uint64 // Nodes evicted to stay within the budget.
+	Len       int    // Number of cached nodes.
+	Bytes     int64  // Approximate memory used by the cached nodes.
+}
+
+// NewLRUCache returns a cache that holds nodes up to about maxBytes of
+// memory, and evicts the least recently used nodes.
+func NewLRUCache(maxBytes int64) Cache {
+	return newShardedCache(func() cachePolicy {
+		return newLRUPolicy(maxBytes/nodeCacheShards, -1)
+	})
+}
+
+// NewTwoQueueCache returns a cache that holds nodes up to about maxBytes of
+// memory, with the 2Q eviction policy. Nodes that are read once are kept in a
+// small queue, so that scans don't evict the nodes that are read frequently,
+// such as the top of the tree.
+func NewTwoQueueCache(maxBytes int64) Cache {
+	return newShardedCache(func() cachePolicy {
+		return newTwoQueuePolicy(maxBytes / nodeCacheShards)
+	})
+}
+
+// newDefaultCache returns an LRU cache that holds nodes up to about
+// DefaultCacheBytes of memory, and at most about maxLen nodes.
+func newDefaultCache(maxLen int) Cache {
+	return newShardedCache(func() cachePolicy {
+		return newLRUPolicy(DefaultCacheBytes/nodeCacheShards, (maxLen+nodeCacheShards-1)/nodeCacheShards)
+	})
+}
+
+// nodeCacheBytes returns the approximate memory used by a cached node. Child
+// nodes are cached separately, and are not included.
+func nodeCacheBytes(node *Node) int64 {
+	return cachedNodeOverhead + int64(len(node.key)+len(node.value)+
+		len(node.hash)+len(node.leftHash)+len(node.rightHash))
+}
+
+// cachePolicy is a cache shard. It is not safe for concurrent use.
+type cachePolicy interface {
+	get(hash []byte) *Node
+	// add adds a node, replacing a cached node with the same hash, and
+	// returns the number of nodes evicted.
+	add(node *Node) (evicted int)
+	remove(hash []byte)
+	len() int
+	bytes() int64
+}
+
+// shardedCache implements Cache by locking and counting around the policies
+// of its shards.
+type shardedCache struct {
+	shards [nodeCacheShards]struct {
+		sync.Mutex
+		policy cachePolicy
+	}
+	hits, misses, evictions uint64 // Accessed atomically.
+}
+
+var _ Cache = (*shardedCache)(nil)
+
+func newShardedCache(newPolicy func() cachePolicy) *shardedCache {
+	c := &shardedCache{}
+	for i := range c.shards {
+		c.shards[i].policy = newPolicy()
+	}
+	return c
+}
+
+func (c *shardedCache) shardIndex(hash []byte) int {
+	return int(hash[0]) % nodeCacheShards
+}
+
+// Get implements Cache.
+func (c *shardedCache) Get(hash []byte) *Node {
+	shard := &c.shards[c.shardIndex(hash)]
+	shard.Lock()
+	node := shard.policy.get(hash)
+	shard.Unlock()
+
+	if node == nil {
+		atomic.AddUint64(&c.misses, 1)
+	} else {
+		atomic.AddUint64(&c.hits, 1)
+	}
+	return node
+}
+
+// Add implements Cache.
+func (c *shardedCache) Add(hash []byte, node *Node) {
+	shard := &c.shards[c.shardIndex(hash)]
+	shard.Lock()
+	evicted := shard.policy.add(node)
+	shard.Unlock()
+
+	if evicted > 0 {
+		atomic.AddUint64(&c.evictions, uint64(evicted))
+	}
+}
+
+// Remove implements Cache.
+func (c *shardedCache) Remove(hash []byte) {
+	shard := &c.shards[c.shardIndex(hash)]
+	shard.Lock()
+	shard.policy.remove(hash)
+	shard.Unlock()
+}
+
+// Len implements Cache.
+func (c *shardedCache) Len() int {
+	n := 0
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.Lock()
+		n += shard.policy.len()
+		shard.Unlock()
+	}
+	return n
+}
+
+// Stats implements Cache.
+func (c *shardedCache) Stats() CacheStats {
+	stats := CacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.Lock()
+		stats.Len += shard.policy.len()
+		stats.Bytes += shard.policy.bytes()
+		shard.Unlock()
+	}
+	return stats
+}
+
+// nodeList is a list of nodes in recency order, with the most recently used
+// node at the back, indexed by hash.
+type nodeList struct {
+	elems map[string]*list.Element
+	queue *list.List
+	size  int64 // Approximate memory used by the nodes.
+}
+
+func newNodeList() *nodeList {
+	return &nodeList{
+		elems: make(map[string]*list.Element),
+		queue: list.New(),
+	}
+}
+
+func (l *nodeList) get(hash []byte) *list.Element {
+	return l.elems[string(hash)]
+}
+
+func (l *nodeList) pushBack(node *Node) {
+	l.elems[string(node.hash)] = l.queue.PushBack(node)
+	l.size += nodeCacheBytes(node)
+}
+
+func (l *nodeList) remove(elem *list.Element) *Node {
+	node := l.queue.Remove(elem).(*Node)
+	delete(l.elems, string(node.hash))
+	l.size -= nodeCacheBytes(node)
+	return node
+}
+
+// removeFront removes the least recently used node.
+func (l *nodeList) removeFront() *Node {
+	return l.remove(l.queue.Front())
+}
+
+func (l *nodeList) len() int {
+	return l.queue.Len()
+}
+
+// lruPolicy evicts the least recently used nodes, to stay within a budget of
+// bytes and/or nodes. A negative budget is ignored.
+type lruPolicy struct {
+	nodes    *nodeList
+	maxBytes int64
+	maxLen   int
+}
+
+func newLRUPolicy(maxBytes int64, maxLen int) *lruPolicy {
+	return &lruPolicy{
+		nodes:    newNodeList(),
+		maxBytes: maxBytes,
+		maxLen:   maxLen,
+	}
+}
+
+func (p *lruPolicy) get(hash []byte) *Node {
+	elem := p.nodes.get(hash)
+	if elem == nil {
+		return nil
+	}
+	p.nodes.queue.MoveToBack(elem)
+	return elem.Value.(*Node)
+}
+
+func (p *lruPolicy) add(node *Node) (evicted int) {
+	p.remove(node.hash)
+	p.nodes.pushBack(node)
+	for (p.maxBytes >= 0 && p.nodes.size > p.maxBytes) || (p.maxLen >= 0 && p.nodes.len() > p.maxLen) {
+		p.nodes.removeFront()
+		evicted++
+	}
+	return evicted
+}
+
+func (p *lruPolicy) remove(hash []byte) {
+	if elem := p.nodes.get(hash); elem != nil {
+		p.nodes.remove(elem)
+	}
+}
+
+func (p *lruPolicy) len() int {
+	return p.nodes.len()
+}
+
+func (p *lruPolicy) bytes() int64 {
+	return p.nodes.size
+}
+
+// twoQueueRecentRatio is the share of the budget of a 2Q cache that holds
+// nodes which have only been read once.
+const twoQueueRecentRatio = 0.25
+
+// twoQueuePolicy implements the 2Q eviction policy. New nodes are added to
+// the recent queue, which is evicted in FIFO order. The hashes of the nodes
+// evicted from it are remembered in the ghost queue, and nodes that are read
+// again while in the recent or ghost queue are moved to the frequent queue,
+// which is evicted in LRU order.
+type twoQueuePolicy struct {
+	recent   *nodeList
+	frequent *nodeList
+	ghosts   map[string]*list.Element
+	ghostLRU *list.List // Hashes of the nodes evicted from recent.
+
+	maxBytes    int64
+	recentBytes int64
+}
+
+func newTwoQueuePolicy(maxBytes int64) *twoQueuePolicy {
+	return &twoQueuePolicy{
+		recent:      newNodeList(),
+		frequent:    newNodeList(),
+		ghosts:      make(map[string]*list.Element),
+		ghostLRU:    list.New(),
+		maxBytes:    maxBytes,
+		recentBytes: int64(float64(maxBytes) * twoQueueRecentRatio),
+	}
+}
+
+func (p *twoQueuePolicy) get(hash []byte) *Node {
+	if elem := p.frequent.get(hash); elem != nil {
+		p.frequent.queue.MoveToBack(elem)
+		return elem.Value.(*Node)
+	}
+	if elem := p.recent.get(hash); elem != nil {
+		node := p.recent.remove(elem)
+		p.frequent.pushBack(node)
+		return node
+	}
+	return nil
+}
+
+func (p *twoQueuePolicy) add(node *Node) (evicted int) {
+	if elem := p.frequent.get(node.hash); elem != nil {
+		p.frequent.remove(elem)
+		p.frequent.pushBack(node)
+	} else if elem := p.recent.get(node.hash); elem != nil {
+		p.recent.remove(elem)
+		p.recent.pushBack(node)
+	} else if elem, ok := p.ghosts[string(node.hash)]; ok {
+		p.ghostLRU.Remove(elem)
+		delete(p.ghosts, string(node.hash))
+		p.frequent.pushBack(node)
+	} else {
+		p.recent.pushBack(node)
+	}
+
+	for p.recent.size+p.frequent.size > p.maxBytes && p.len() > 0 {
+		if p.recent.len() > 0 && (p.recent.size > p.recentBytes || p.frequent.len() == 0) {
+			evictedNode := p.recent.removeFront()
+			p.ghosts[string(evictedNode.hash)] = p.ghostLRU.PushBack(evictedNode.hash)
+		} else {
+			p.frequent.removeFront()
+		}
+		evicted++
+	}
+
+	// Remember about as many evicted nodes as there are cached nodes.
+	for p.ghostLRU.Len() > 0 && p.ghostLRU.Len() > p.recent.len()+p.frequent.len() {
+		hash := p.ghostLRU.Remove(p.ghostLRU.Front()).([]byte)
+		delete(p.ghosts, string(hash))
+	}
+	return evicted
+}
+
+func (p *twoQueuePolicy) remove(hash []byte) {
+	if elem := p.recent.get(hash); elem != nil {
+		p.recent.remove(elem)
+	}
+	if elem := p.frequent.get(hash); elem != nil {
+		p.frequent.remove(elem)
+	}
+	if elem, ok := p.ghosts[string(hash)]; ok {
+		p.ghostLRU.Remove(elem)
+		delete(p.ghosts, string(hash))
+	}
+}
+
+func (p *twoQueuePolicy) len() int {
+	return p.recent.len() + p.frequent.len()
+}
+
+func (p *twoQueuePolicy) bytes() int64 {
+	return p.recent.size + p.frequent.size
+}
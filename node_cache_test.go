@@ -0,0 +1,193 @@
+package iavl
+
+import (
+	"crypto/sha256"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func testCacheNode(i int, valueSize int) *Node {
+	node := NewNode([]byte{byte(i >> 8), byte(i)}, make([]byte, valueSize), 1)
+	node._hash(SHA256)
+	return node
+}
+
+func TestLRUPolicy(t *testing.T) {
+	size := nodeCacheBytes(testCacheNode(0, 100))
+	p := newLRUPolicy(3*size, -1)
+	nodes := []*Node{}
+	for i := 0; i < 3; i++ {
+		nodes = append(nodes, testCacheNode(i, 100))
+		require.Equal(t, 0, p.add(nodes[i]))
+	}
+	require.Equal(t, 3*size, p.bytes())
+
+	// Reading node 0 makes node 1 the least recently used.
+	require.Equal(t, nodes[0], p.get(nodes[0].hash))
+	require.Equal(t, 1, p.add(testCacheNode(3, 100)))
+	require.Nil(t, p.get(nodes[1].hash))
+	require.NotNil(t, p.get(nodes[0].hash))
+
+	// A large node evicts several small ones.
+	require.Equal(t, 3, p.add(testCacheNode(4, int(2*size))))
+	require.Equal(t, 1, p.len())
+	// A node larger than the budget is not cached at all.
+	require.Equal(t, 2, p.add(testCacheNode(5, int(4*size))))
+	require.Equal(t, 0, p.len())
+	require.Equal(t, int64(0), p.bytes())
+
+	// Budget by number of nodes.
+	p = newLRUPolicy(-1, 2)
+	for i := 0; i < 3; i++ {
+		p.add(testCacheNode(i, 1000))
+	}
+	require.Equal(t, 2, p.len())
+}
+
+func TestTwoQueuePolicy(t *testing.T) {
+	size := nodeCacheBytes(testCacheNode(0, 100))
+	p := newTwoQueuePolicy(8 * size)
+
+	// Nodes that are read again are moved to the frequent queue.
+	hot := []*Node{}
+	for i := 0; i < 4; i++ {
+		hot = append(hot, testCacheNode(i, 100))
+		p.add(hot[i])
+		require.Equal(t, hot[i], p.get(hot[i].hash))
+	}
+	require.Equal(t, 4, p.frequent.len())
+
+	// A scan only evicts nodes read once.
+	for i := 100; i < 200; i++ {
+		p.add(testCacheNode(i, 100))
+	}
+	for _, node := range hot {
+		require.Equal(t, node, p.get(node.hash))
+	}
+	require.True(t, p.bytes() <= 8*size)
+
+	// Nodes evicted from the recent queue are remembered, and go to the
+	// frequent queue when added again.
+	evicted := testCacheNode(195, 100)
+	require.Nil(t, p.get(evicted.hash))
+	require.Contains(t, p.ghosts, string(evicted.hash))
+	p.add(evicted)
+	require.NotNil(t, p.frequent.get(evicted.hash))
+
+	p.remove(evicted.hash)
+	require.Nil(t, p.get(evicted.hash))
+}
+
+func TestCacheStats(t *testing.T) {
+	for _, cache := range []Cache{NewLRUCache(1 << 20), NewTwoQueueCache(1 << 20)} {
+		tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Cache: cache})
+		for i := 0; i < 1000; i++ {
+			tree.Set(randBytes(8), randBytes(100))
+		}
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		stats := tree.CacheStats()
+		require.Equal(t, uint64(0), stats.Hits)
+		require.True(t, stats.Len > 0)
+		require.True(t, stats.Bytes > 0 && stats.Bytes <= 1<<20)
+
+		itree, err := tree.GetImmutable(1)
+		require.NoError(t, err)
+		itree.Iterate(func(key, value []byte) bool { return false })
+		stats = tree.CacheStats()
+		require.True(t, stats.Hits > 0)
+
+		// A smaller cache misses and evicts.
+		small := NewLRUCache(10000)
+		tree = NewMutableTreeWithOpts(tree.ndb.db, 0, &Options{Cache: small})
+		_, err = tree.Load()
+		require.NoError(t, err)
+		tree.Iterate(func(key, value []byte) bool { return false })
+		stats = tree.CacheStats()
+		require.True(t, stats.Misses > 0)
+		require.True(t, stats.Evictions > 0)
+		require.True(t, stats.Bytes <= 10000)
+	}
+}
+
+func TestDefaultCache(t *testing.T) {
+	// The default cache is limited by the number of nodes.
+	cache := newDefaultCache(10 * nodeCacheShards)
+	for i := 0; i < 1000; i++ {
+		node := testCacheNode(i, 10)
+		cache.Add(node.hash, node)
+	}
+	require.Equal(t, 10*nodeCacheShards, cache.Len())
+
+	// And by memory.
+	cache = newDefaultCache(1000)
+	value := make([]byte, 1<<20)
+	for i := 0; i < 1000; i++ {
+		node := NewNode([]byte{byte(i >> 8), byte(i)}, value, 1)
+		hash := sha256.Sum256(node.key)
+		node.hash = hash[:]
+		cache.Add(node.hash, node)
+	}
+	require.True(t, cache.Len() > 0)
+	require.True(t, cache.Stats().Bytes <= DefaultCacheBytes)
+}
+
+// mapCache is a Cache implemented with the exported interface only.
+type mapCache struct {
+	sync.Mutex
+	nodes map[string]*Node
+	hits  uint64
+}
+
+func (c *mapCache) Get(hash []byte) *Node {
+	c.Lock()
+	defer c.Unlock()
+	node := c.nodes[string(hash)]
+	if node != nil {
+		c.hits++
+	}
+	return node
+}
+
+func (c *mapCache) Add(hash []byte, node *Node) {
+	c.Lock()
+	defer c.Unlock()
+	c.nodes[string(hash)] = node
+}
+
+func (c *mapCache) Remove(hash []byte) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.nodes, string(hash))
+}
+
+func (c *mapCache) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.nodes)
+}
+
+func (c *mapCache) Stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return CacheStats{Hits: c.hits, Len: len(c.nodes)}
+}
+
+func TestCustomCache(t *testing.T) {
+	cache := &mapCache{nodes: map[string]*Node{}}
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Cache: cache})
+	for i := 0; i < 100; i++ {
+		tree.Set(randBytes(8), randBytes(8))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	require.Equal(t, 2*100-1, cache.Len())
+
+	itree, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	itree.Iterate(func(key, value []byte) bool { return false })
+	require.True(t, tree.CacheStats().Hits > 0)
+}
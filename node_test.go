@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
 )
 
 func TestNode_aminoSize(t *testing.T) {
@@ -31,6 +32,29 @@ func TestNode_aminoSize(t *testing.T) {
 	require.Equal(t, 57, node.aminoSize())
 }
 
+func TestNode_hashWithCountParallel(t *testing.T) {
+	serial := NewMutableTree(db.NewMemDB(), 0)
+	parallel := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{HashWorkers: 4})
+
+	for v := 0; v < 5; v++ {
+		for i := 0; i < 5000; i++ {
+			key, value := randBytes(8), randBytes(8)
+			serial.Set(key, value)
+			parallel.Set(key, value)
+		}
+		expected, expectedCount := serial.root.hashWithCount(SHA256)
+		hash, count := parallel.root.hashWithCountParallel(SHA256, parallel.hashSem)
+		require.Equal(t, expected, hash)
+		require.Equal(t, expectedCount, count)
+
+		serialHash, _, err := serial.SaveVersion()
+		require.NoError(t, err)
+		parallelHash, _, err := parallel.SaveVersion()
+		require.NoError(t, err)
+		require.Equal(t, serialHash, parallelHash)
+	}
+}
+
 func BenchmarkNode_aminoSize(b *testing.B) {
 	node := &Node{
 		key:       randBytes(25),
@@ -2,11 +2,14 @@ package iavl
 
 import (
 	"bytes"
-	"container/list"
+	"encoding/binary"
 	"fmt"
 	"sort"
 	"sync"
+	"sync/atomic"
 
+	"github.com/pkg/errors"
+	amino "github.com/tendermint/go-amino"
 	"github.com/tendermint/tendermint/crypto/tmhash"
 	dbm "github.com/tendermint/tm-db"
 )
@@ -30,53 +33,89 @@ var (
 
 	// Root nodes are indexed separately by their version
 	rootKeyFormat = NewKeyFormat('r', int64Size) // r<version>
+
+	// Fast nodes are indexed by their key, followed by the key itself, and
+	// hold the latest value and version of the key.
+	fastKeyFormat = NewKeyFormat('f') // f<key>
+
+	// The fast index state holds the version and root hash of the tree that
+	// the fast nodes are current with.
+	fastIndexKeyFormat = NewKeyFormat('F') // F
+
+	// Operations that are written in several batches record the version they
+	// write in their first batch, and remove it in their last one, so that
+	// they can be rolled back if interrupted.
+	pendingKeyFormat = NewKeyFormat('p', int64Size) // p<version>
+
+	// The hashes of the nodes written by each batch of a pending operation,
+	// which are deleted if it is rolled back.
+	pendingNodesKeyFormat = NewKeyFormat('P', int64Size, int64Size) // P<version><batch>
+
+	// The tree metadata records how the tree was written.
+	metadataKeyFormat = NewKeyFormat('m') // m
 )
 
+// nodeDB reads and writes the nodes of a tree. Nodes can be read from any
+// number of goroutines concurrently with a single writer, since reads only
+// touch the database and the node cache, which are both safe for concurrent
+// use. All other methods are for the writer, and must not be called
+// concurrently.
 type nodeDB struct {
 	mtx   sync.Mutex // Read/write lock.
 	db    dbm.DB     // Persistent node storage.
 	batch dbm.Batch  // Batched writing buffer.
 
-	latestVersion  int64
-	nodeCache      map[string]*list.Element // Node cache.
-	nodeCacheSize  int                      // Node cache size limit in elements.
-	nodeCacheQueue *list.List               // LRU queue of cache elements. Used for deletion.
+	latestVersion int64
+	nodeCache     Cache   // Node cache, safe for concurrent use.
+	metrics       Metrics // Measurements of node reads and writes.
+	logger        Logger
+	logNodes      bool   // Whether per-node events are logged, false for the nop logger.
+	hasher        Hasher // Hash function of the nodes.
+
+	metadataChecked bool // Whether the metadata matches this release.
+	metadataWritten bool // Whether the metadata is on disk or in the batch.
+
+	fastIndex        bool   // Whether the fast node index is maintained.
+	fastIndexLoaded  bool   // Whether the fast index state has been read from disk.
+	fastIndexVersion int64  // Version the fast node index is current with, 0 if none.
+	fastIndexRoot    []byte // Root hash the fast node index is current with.
+	fastIndexGen     uint64 // Incremented atomically whenever the index changes.
 }
 
-func newNodeDB(db dbm.DB, cacheSize int) *nodeDB {
+func newNodeDB(db dbm.DB, cache Cache) *nodeDB {
 	ndb := &nodeDB{
-		db:             db,
-		batch:          db.NewBatch(),
-		latestVersion:  0, // initially invalid
-		nodeCache:      make(map[string]*list.Element),
-		nodeCacheSize:  cacheSize,
-		nodeCacheQueue: list.New(),
+		db:            db,
+		batch:         db.NewBatch(),
+		latestVersion: 0, // initially invalid
+		nodeCache:     cache,
+		metrics:       NopMetrics{},
+		logger:        NewNopLogger(),
+		hasher:        SHA256,
 	}
 	return ndb
 }
 
 // GetNode gets a node from cache or disk. If it is an inner node, it does not
-// load its children.
+// load its children. It does not take the nodeDB lock, so that readers don't
+// contend with the writer.
 func (ndb *nodeDB) GetNode(hash []byte) *Node {
-	ndb.mtx.Lock()
-	defer ndb.mtx.Unlock()
-
 	if len(hash) == 0 {
 		panic("nodeDB.GetNode() requires hash")
 	}
 
 	// Check the cache.
-	if elem, ok := ndb.nodeCache[string(hash)]; ok {
-		// Already exists. Move to back of nodeCacheQueue.
-		ndb.nodeCacheQueue.MoveToBack(elem)
-		return elem.Value.(*Node)
+	if node := ndb.nodeCache.Get(hash); node != nil {
+		ndb.metrics.CacheHit()
+		return node
 	}
+	ndb.metrics.CacheMiss()
 
 	// Doesn't exist, load.
 	buf := ndb.db.Get(ndb.nodeKey(hash))
 	if buf == nil {
 		panic(fmt.Sprintf("Value missing for hash %x corresponding to nodeKey %s", hash, ndb.nodeKey(hash)))
 	}
+	ndb.metrics.DBRead(len(buf))
 
 	node, err := MakeNode(buf)
 	if err != nil {
@@ -85,7 +124,7 @@ func (ndb *nodeDB) GetNode(hash []byte) *Node {
 
 	node.hash = hash
 	node.persisted = true
-	ndb.cacheNode(node)
+	ndb.nodeCache.Add(node.hash, node)
 
 	return node
 }
@@ -109,10 +148,13 @@ func (ndb *nodeDB) SaveNode(node *Node) {
 		panic(err)
 	}
 	ndb.batch.Set(ndb.nodeKey(node.hash), buf.Bytes())
-	debug("BATCH SAVE %X %p\n", node.hash, node)
+	ndb.metrics.NodeSaved(buf.Len())
+	if ndb.logNodes {
+		ndb.logger.Debug("save node", "hash", node.hash, "version", node.version)
+	}
 
 	node.persisted = true
-	ndb.cacheNode(node)
+	ndb.nodeCache.Add(node.hash, node)
 }
 
 // Has checks if a hash exists in the database.
@@ -145,7 +187,7 @@ func (ndb *nodeDB) SaveBranch(node *Node) []byte {
 		node.rightHash = ndb.SaveBranch(node.rightNode)
 	}
 
-	node._hash()
+	node._hash(ndb.hasher)
 	ndb.SaveNode(node)
 
 	node.leftNode = nil
@@ -172,9 +214,12 @@ func (ndb *nodeDB) SaveOrphans(version int64, orphans map[string]int64) {
 
 	toVersion := ndb.getPreviousVersion(version)
 	for hash, fromVersion := range orphans {
-		debug("SAVEORPHAN %v-%v %X\n", fromVersion, toVersion, hash)
+		if ndb.logNodes {
+			ndb.logger.Debug("save orphan", "hash", []byte(hash), "from", fromVersion, "to", toVersion)
+		}
 		ndb.saveOrphan([]byte(hash), fromVersion, toVersion)
 	}
+	ndb.metrics.OrphansSaved(len(orphans))
 }
 
 // Saves a single orphan to disk.
@@ -186,19 +231,99 @@ func (ndb *nodeDB) saveOrphan(hash []byte, fromVersion, toVersion int64) {
 	ndb.batch.Set(key, hash)
 }
 
+// DeleteVersionsRange deletes all tree versions in [fromVersion, toVersion)
+// from disk, traversing the orphans of the whole range only once.
+func (ndb *nodeDB) DeleteVersionsRange(fromVersion, toVersion int64) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+
+	ndb.deleteOrphansRange(fromVersion, toVersion)
+
+	itr := ndb.db.Iterator(ndb.rootKey(fromVersion), ndb.rootKey(toVersion))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		var version int64
+		rootKeyFormat.Scan(itr.Key(), &version)
+		ndb.deleteRoot(version, true)
+	}
+}
+
+// DeleteVersionsFrom deletes all tree versions from the given one onwards
+// from disk, in a single batch. Besides their orphans, this deletes the nodes
+// they created that are still part of the latest version, which have no
+// orphan entries.
+func (ndb *nodeDB) DeleteVersionsFrom(version int64) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+
+	latest := ndb.getLatestVersion()
+	if version > latest {
+		return
+	}
+
+	// Orphans ending at the predecessor or later were orphaned by one of the
+	// deleted versions. Those created by them are deleted, while the others
+	// are part of the predecessor, which becomes the latest version, and are
+	// no longer orphans.
+	predecessor := ndb.getPreviousVersion(version)
+	ndb.traverseOrphansRange(predecessor, latest+1, func(key, hash []byte) {
+		var fromVersion, toVersion int64
+		orphanKeyFormat.Scan(key, &toVersion, &fromVersion)
+		ndb.batch.Delete(key)
+		if fromVersion > predecessor {
+			ndb.batch.Delete(ndb.nodeKey(hash))
+			ndb.nodeCache.Remove(hash)
+		}
+	})
+
+	// Nodes are never newer than their parents, so only subtrees with a root
+	// created in one of the deleted versions need to be traversed.
+	if rootHash := ndb.getRoot(latest); len(rootHash) > 0 {
+		stack := [][]byte{rootHash}
+		for len(stack) > 0 {
+			hash := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			node := ndb.GetNode(hash)
+			if node.version < version {
+				continue
+			}
+			ndb.batch.Delete(ndb.nodeKey(hash))
+			ndb.nodeCache.Remove(hash)
+			if !node.isLeaf() {
+				stack = append(stack, node.leftHash, node.rightHash)
+			}
+		}
+	}
+
+	itr := ndb.db.Iterator(ndb.rootKey(version), ndb.rootKey(latest+1))
+	defer itr.Close()
+	for ; itr.Valid(); itr.Next() {
+		ndb.batch.Delete(itr.Key())
+	}
+}
+
 // deleteOrphans deletes orphaned nodes from disk, and the associated orphan
 // entries.
 func (ndb *nodeDB) deleteOrphans(version int64) {
+	ndb.deleteOrphansRange(version, version+1)
+}
+
+// deleteOrphansRange deletes the orphans of all versions in
+// [fromVersion, toVersion), as if the versions were deleted one by one in
+// ascending order. Since all of them are deleted, they share the same
+// predecessor.
+func (ndb *nodeDB) deleteOrphansRange(fromVersion, toVersion int64) {
 	// Will be zero if there is no previous version.
-	predecessor := ndb.getPreviousVersion(version)
+	predecessor := ndb.getPreviousVersion(fromVersion)
+	deleted, moved := 0, 0
 
-	// Traverse orphans with a lifetime ending at the version specified.
+	// Traverse orphans with a lifetime ending at one of the versions specified.
 	// TODO optimize.
-	ndb.traverseOrphansVersion(version, func(key, hash []byte) {
+	ndb.traverseOrphansRange(fromVersion, toVersion, func(key, hash []byte) {
 		var fromVersion, toVersion int64
 
-		// See comment on `orphanKeyFmt`. Note that here, `version` and
-		// `toVersion` are always equal.
+		// See comment on `orphanKeyFmt`. Note that here, `toVersion` is
+		// always one of the versions being deleted.
 		orphanKeyFormat.Scan(key, &toVersion, &fromVersion)
 
 		// Delete orphan key and reverse-lookup key.
@@ -210,14 +335,271 @@ func (ndb *nodeDB) deleteOrphans(version int64) {
 		// can delete the orphan.  Otherwise, we shorten its lifetime, by
 		// moving its endpoint to the previous version.
 		if predecessor < fromVersion || fromVersion == toVersion {
-			debug("DELETE predecessor:%v fromVersion:%v toVersion:%v %X\n", predecessor, fromVersion, toVersion, hash)
+			if ndb.logNodes {
+				ndb.logger.Debug("delete orphan", "hash", hash, "from", fromVersion, "to", toVersion, "predecessor", predecessor)
+			}
 			ndb.batch.Delete(ndb.nodeKey(hash))
-			ndb.uncacheNode(hash)
+			ndb.nodeCache.Remove(hash)
+			deleted++
 		} else {
-			debug("MOVE predecessor:%v fromVersion:%v toVersion:%v %X\n", predecessor, fromVersion, toVersion, hash)
+			if ndb.logNodes {
+				ndb.logger.Debug("move orphan", "hash", hash, "from", fromVersion, "to", toVersion, "predecessor", predecessor)
+			}
 			ndb.saveOrphan(hash, fromVersion, predecessor)
+			moved++
+		}
+	})
+	ndb.logger.Debug("deleted orphans", "from", fromVersion, "to", toVersion, "deleted", deleted, "moved", moved)
+	ndb.metrics.OrphansDeleted(deleted)
+}
+
+// setPending records in the batch that a multi-batch operation writing the
+// given version has started.
+func (ndb *nodeDB) setPending(version int64) {
+	ndb.batch.Set(pendingKeyFormat.Key(version), []byte{})
+}
+
+// savePendingNodes records in the batch the hashes of the nodes that the
+// pending operation writing the given version adds to it.
+func (ndb *nodeDB) savePendingNodes(version, batch int64, hashes [][]byte) {
+	if len(hashes) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for _, hash := range hashes {
+		if err := amino.EncodeByteSlice(&buf, hash); err != nil {
+			panic(err)
+		}
+	}
+	ndb.batch.Set(pendingNodesKeyFormat.Key(version, batch), buf.Bytes())
+}
+
+// clearPending records in the batch that the operation writing the given
+// version is done, along with the nodes it recorded.
+func (ndb *nodeDB) clearPending(version int64) {
+	ndb.traversePrefix(pendingNodesKeyFormat.Key(version), func(k, v []byte) {
+		ndb.batch.Delete(k)
+	})
+	ndb.batch.Delete(pendingKeyFormat.Key(version))
+}
+
+// getPending returns the versions written by interrupted operations.
+func (ndb *nodeDB) getPending() []int64 {
+	versions := []int64{}
+	ndb.traversePrefix(pendingKeyFormat.Key(), func(k, v []byte) {
+		var version int64
+		pendingKeyFormat.Scan(k, &version)
+		versions = append(versions, version)
+	})
+	return versions
+}
+
+// rollbackPending rolls back the operations that were interrupted by a
+// crash, by deleting the nodes they recorded and their root. Each batch of
+// nodes is deleted with its record, so an interrupted rollback is resumed by
+// the next one.
+func (ndb *nodeDB) rollbackPending() error {
+	versions := ndb.getPending()
+	if len(versions) == 0 {
+		return nil
+	}
+	ndb.logger.Info("rolling back interrupted operations", "versions", versions)
+	for _, version := range versions {
+		records := [][]byte{}
+		ndb.traversePrefix(pendingNodesKeyFormat.Key(version), func(k, v []byte) {
+			records = append(records, k)
+		})
+		for _, k := range records {
+			buf := ndb.db.Get(k)
+			for len(buf) > 0 {
+				hash, n, err := amino.DecodeByteSlice(buf)
+				if err != nil {
+					return errors.Wrapf(err, "decoding nodes of interrupted operation at version %d", version)
+				}
+				ndb.batch.Delete(ndb.nodeKey(hash))
+				ndb.nodeCache.Remove(hash)
+				buf = buf[n:]
+			}
+			ndb.batch.Delete(k)
+			ndb.Commit()
 		}
+		ndb.batch.Delete(ndb.rootKey(version))
+		ndb.batch.Delete(pendingKeyFormat.Key(version))
+		ndb.Commit()
+	}
+	return nil
+}
+
+// getFastNode returns the fast node for a key, or nil if the key does not
+// exist in the indexed version.
+func (ndb *nodeDB) getFastNode(key []byte) *fastNode {
+	buf := ndb.db.Get(ndb.fastNodeKey(key))
+	if buf == nil {
+		return nil
+	}
+	fastNode, err := makeFastNode(key, buf)
+	if err != nil {
+		panic(fmt.Sprintf("Error reading fastNode. bytes: %x, error: %v", buf, err))
+	}
+	return fastNode
+}
+
+// saveFastNode saves a fast node to disk.
+func (ndb *nodeDB) saveFastNode(fastNode *fastNode) {
+	ndb.batch.Set(ndb.fastNodeKey(fastNode.key), fastNode.bytes())
+}
+
+// deleteFastNode deletes the fast node of a key from disk.
+func (ndb *nodeDB) deleteFastNode(key []byte) {
+	ndb.batch.Delete(ndb.fastNodeKey(key))
+}
+
+// hasFastIndex returns whether the fast node index is current with the tree
+// with the given root hash. Readers don't call it for every read, but once per
+// tree with markFastIndex.
+func (ndb *nodeDB) hasFastIndex(rootHash []byte) bool {
+	if !ndb.fastIndex {
+		return false
+	}
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.loadFastIndexState()
+	return bytes.Equal(ndb.fastIndexRoot, rootHash)
+}
+
+// getFastIndexState returns the version and root hash the fast node index is
+// current with, or 0 if there is no complete index.
+func (ndb *nodeDB) getFastIndexState() (version int64, rootHash []byte) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.loadFastIndexState()
+	return ndb.fastIndexVersion, ndb.fastIndexRoot
+}
+
+// loadFastIndexState reads the state of the fast node index from disk, once.
+// The caller must hold the lock.
+func (ndb *nodeDB) loadFastIndexState() {
+	if ndb.fastIndexLoaded {
+		return
+	}
+	// The state is stored as <version><root hash>.
+	if buf := ndb.db.Get(fastIndexKeyFormat.Key()); len(buf) >= int64Size {
+		ndb.fastIndexVersion = int64(binary.BigEndian.Uint64(buf))
+		ndb.fastIndexRoot = buf[int64Size:]
+	}
+	ndb.fastIndexLoaded = true
+}
+
+// saveFastIndexState records that the fast node index is current with the
+// given version and root hash.
+func (ndb *nodeDB) saveFastIndexState(version int64, rootHash []byte) {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.batch.Set(fastIndexKeyFormat.Key(), append(formatUint64(uint64(version)), rootHash...))
+	ndb.fastIndexVersion, ndb.fastIndexRoot = version, rootHash
+	ndb.fastIndexLoaded = true
+	atomic.AddUint64(&ndb.fastIndexGen, 1)
+}
+
+// clearFastIndexState records in the batch that the fast node index is not
+// current with any version, before it is modified in several batches. This
+// makes sure that a partially modified index is never used after a crash.
+func (ndb *nodeDB) clearFastIndexState() {
+	ndb.mtx.Lock()
+	defer ndb.mtx.Unlock()
+	ndb.batch.Delete(fastIndexKeyFormat.Key())
+	ndb.fastIndexVersion, ndb.fastIndexRoot = 0, nil
+	ndb.fastIndexLoaded = true
+	atomic.AddUint64(&ndb.fastIndexGen, 1)
+}
+
+// rebuildFastIndex replaces the fast node index with the leaves of the given
+// tree, and commits it.
+func (ndb *nodeDB) rebuildFastIndex(t *ImmutableTree) {
+	ndb.logger.Info("rebuilding fast index", "version", t.version)
+	ndb.clearFastIndexState()
+	ndb.traversePrefix(fastKeyFormat.Key(), func(k, v []byte) {
+		ndb.batch.Delete(k)
 	})
+	ndb.Commit()
+
+	count := 0
+	if t.root != nil {
+		t.root.traverse(t, true, func(node *Node) bool {
+			if node.isLeaf() {
+				ndb.saveFastNode(&fastNode{key: node.key, value: node.value, version: node.version})
+				count++
+				if count%importBatchSize == 0 {
+					ndb.Commit()
+				}
+			}
+			return false
+		})
+	}
+	ndb.saveFastIndexState(t.version, t.Hash())
+	ndb.Commit()
+}
+
+// updateFastIndex brings the fast node index from the tree it is current with
+// to the given tree, only writing the keys whose leaves differ, and commits
+// it.
+func (ndb *nodeDB) updateFastIndex(from, to *ImmutableTree) {
+	ndb.logger.Info("updating fast index", "from", from.version, "to", to.version)
+	ndb.clearFastIndexState()
+	count := 0
+	diffLeaves(from, to, nil, func(fromLeaf, toLeaf *Node) {
+		if toLeaf == nil {
+			ndb.deleteFastNode(fromLeaf.key)
+		} else {
+			ndb.saveFastNode(&fastNode{key: toLeaf.key, value: toLeaf.value, version: toLeaf.version})
+		}
+		count++
+		if count%importBatchSize == 0 {
+			ndb.Commit()
+		}
+	})
+	ndb.saveFastIndexState(to.version, to.Hash())
+	ndb.Commit()
+}
+
+// traverseFastNodes traverses the fast nodes with keys in [start, end), or
+// [start, end] if inclusive. Either bound may be nil for an open range.
+func (ndb *nodeDB) traverseFastNodes(start, end []byte, ascending, inclusive bool, fn func(*fastNode) bool) bool {
+	prefix := fastKeyFormat.Key()
+	itrStart, itrEnd := prefix, cpIncr(prefix)
+	if start != nil {
+		itrStart = ndb.fastNodeKey(start)
+	}
+	if end != nil {
+		itrEnd = ndb.fastNodeKey(end)
+		if inclusive {
+			itrEnd = append(itrEnd, 0x00)
+		}
+	}
+
+	var itr dbm.Iterator
+	if ascending {
+		itr = ndb.db.Iterator(itrStart, itrEnd)
+	} else {
+		itr = ndb.db.ReverseIterator(itrStart, itrEnd)
+	}
+	defer itr.Close()
+
+	for ; itr.Valid(); itr.Next() {
+		key := itr.Key()[len(prefix):]
+		fastNode, err := makeFastNode(key, itr.Value())
+		if err != nil {
+			panic(fmt.Sprintf("Error reading fastNode. bytes: %x, error: %v", itr.Value(), err))
+		}
+		if fn(fastNode) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ndb *nodeDB) fastNodeKey(key []byte) []byte {
+	return append(fastKeyFormat.Key(), key...)
 }
 
 func (ndb *nodeDB) nodeKey(hash []byte) []byte {
@@ -280,9 +662,14 @@ func (ndb *nodeDB) traverseOrphans(fn func(k, v []byte)) {
 	ndb.traversePrefix(orphanKeyFormat.Key(), fn)
 }
 
-// Traverse orphans ending at a certain version.
-func (ndb *nodeDB) traverseOrphansVersion(version int64, fn func(k, v []byte)) {
-	ndb.traversePrefix(orphanKeyFormat.Key(version), fn)
+// Traverse orphans ending at a version in [fromVersion, toVersion).
+func (ndb *nodeDB) traverseOrphansRange(fromVersion, toVersion int64, fn func(k, v []byte)) {
+	itr := ndb.db.Iterator(orphanKeyFormat.Key(fromVersion), orphanKeyFormat.Key(toVersion))
+	defer itr.Close()
+
+	for ; itr.Valid(); itr.Next() {
+		fn(itr.Key(), itr.Value())
+	}
 }
 
 // Traverse all keys.
@@ -305,26 +692,6 @@ func (ndb *nodeDB) traversePrefix(prefix []byte, fn func(k, v []byte)) {
 	}
 }
 
-func (ndb *nodeDB) uncacheNode(hash []byte) {
-	if elem, ok := ndb.nodeCache[string(hash)]; ok {
-		ndb.nodeCacheQueue.Remove(elem)
-		delete(ndb.nodeCache, string(hash))
-	}
-}
-
-// Add a node to the cache and pop the least recently used node if we've
-// reached the cache size limit.
-func (ndb *nodeDB) cacheNode(node *Node) {
-	elem := ndb.nodeCacheQueue.PushBack(node)
-	ndb.nodeCache[string(node.hash)] = elem
-
-	if ndb.nodeCacheQueue.Len() > ndb.nodeCacheSize {
-		oldest := ndb.nodeCacheQueue.Front()
-		hash := ndb.nodeCacheQueue.Remove(oldest).(*Node).hash
-		delete(ndb.nodeCache, string(hash))
-	}
-}
-
 // Write to disk.
 func (ndb *nodeDB) Commit() {
 	ndb.mtx.Lock()
@@ -356,22 +723,30 @@ func (ndb *nodeDB) SaveRoot(root *Node, version int64) error {
 	if len(root.hash) == 0 {
 		panic("Hash should not be empty")
 	}
-	return ndb.saveRoot(root.hash, version)
+	return ndb.saveRoot(root.hash, version, false)
 }
 
 // SaveEmptyRoot creates an entry on disk for an empty root.
 func (ndb *nodeDB) SaveEmptyRoot(version int64) error {
-	return ndb.saveRoot([]byte{}, version)
+	return ndb.saveRoot([]byte{}, version, false)
 }
 
-func (ndb *nodeDB) saveRoot(hash []byte, version int64) error {
+// saveRoot saves the root of the version, which must follow the latest
+// version, or be later than it if gaps are allowed.
+func (ndb *nodeDB) saveRoot(hash []byte, version int64, allowGaps bool) error {
 	ndb.mtx.Lock()
 	defer ndb.mtx.Unlock()
 
-	if version != ndb.getLatestVersion()+1 {
-		return fmt.Errorf("must save consecutive versions. Expected %d, got %d", ndb.getLatestVersion()+1, version)
+	switch latest := ndb.getLatestVersion(); {
+	case allowGaps && version <= latest:
+		return fmt.Errorf("must save increasing versions. Expected more than %d, got %d", latest, version)
+	case !allowGaps && version != latest+1:
+		return fmt.Errorf("must save consecutive versions. Expected %d, got %d", latest+1, version)
 	}
 
+	if err := ndb.saveMetadata(); err != nil {
+		return err
+	}
 	key := ndb.rootKey(version)
 	ndb.batch.Set(key, hash)
 	ndb.updateLatestVersion(version)
@@ -0,0 +1,107 @@
+package iavl
+
+// Options define tree options.
+type Options struct {
+	// Pruning defines which versions are kept when saving new versions.
+	Pruning PruningOptions
+
+	// KeepInMemory makes SaveVersion keep new versions in memory only, and
+	// write a version to disk every FlushInterval versions or on Flush. Nodes
+	// that are orphaned before they are flushed never touch the database.
+	// After a restart, the tree is back at the last flushed version.
+	KeepInMemory bool
+
+	// FlushInterval is the number of versions between flushes to disk with
+	// KeepInMemory. Zero or one flushes every version. Pruning runs on every
+	// flush, regardless of Pruning.Interval.
+	FlushInterval int64
+
+	// FastIndex maintains an index from each key of the latest version to its
+	// value, so that reads of the latest version don't have to traverse the
+	// tree. Existing databases are indexed when the tree is loaded.
+	FastIndex bool
+
+	// HashWorkers is the number of goroutines that hash the dirty nodes of the
+	// working tree, when saving a version or computing the working hash. Zero
+	// or one hashes on the calling goroutine only.
+	HashWorkers int
+
+	// Cache is the node cache, such as NewLRUCache or NewTwoQueueCache, which
+	// are limited by memory rather than by the number of nodes. If nil, an
+	// LRU cache of DefaultCacheBytes is used, which also holds at most the
+	// number of nodes passed to the constructor. Caches must not be shared
+	// between trees.
+	Cache Cache
+
+	// Metrics receives measurements of the tree's operations. If nil,
+	// NopMetrics are used.
+	Metrics Metrics
+
+	// Logger receives events such as saved versions and pruning decisions.
+	// If nil, events are discarded.
+	Logger Logger
+
+	// Hasher is the hash function of the tree, recorded in the database when
+	// the first version is saved. Loading a tree with a different hasher
+	// fails. If nil, SHA256 is used.
+	Hasher Hasher
+}
+
+// DefaultOptions returns the default options, which keep all versions.
+func DefaultOptions() *Options {
+	return &Options{
+		Pruning: PruneNothing(),
+	}
+}
+
+// PruningOptions define the versions a MutableTree keeps on disk. Versions
+// that fall outside the policy are deleted by SaveVersion.
+type PruningOptions struct {
+	// KeepRecent is the number of most recent versions to keep. Zero keeps
+	// all versions and disables pruning.
+	KeepRecent int64
+
+	// KeepEvery keeps every version that is a multiple of it, in addition to
+	// the recent ones. Zero disables it.
+	KeepEvery int64
+
+	// Interval is the number of versions between pruning runs. Deleting many
+	// versions at once is cheaper than one at a time. Zero or one prunes on
+	// every SaveVersion.
+	Interval int64
+}
+
+// PruneNothing returns pruning options that keep all versions.
+func PruneNothing() PruningOptions {
+	return PruningOptions{}
+}
+
+// PruneEverything returns pruning options that only keep the latest version.
+func PruneEverything() PruningOptions {
+	return PruningOptions{KeepRecent: 1}
+}
+
+// NewPruningOptions returns pruning options that keep the most recent
+// keepRecent versions and every keepEvery-th version, pruning every interval
+// versions.
+func NewPruningOptions(keepRecent, keepEvery, interval int64) PruningOptions {
+	return PruningOptions{
+		KeepRecent: keepRecent,
+		KeepEvery:  keepEvery,
+		Interval:   interval,
+	}
+}
+
+// shouldPrune returns whether pruning should run after saving version.
+func (opts PruningOptions) shouldPrune(version int64) bool {
+	return opts.KeepRecent > 0 && (opts.Interval <= 1 || version%opts.Interval == 0)
+}
+
+// keep returns whether the given version is kept when latest is the latest
+// saved version.
+func (opts PruningOptions) keep(version, latest int64) bool {
+	if opts.KeepRecent <= 0 || version > latest-opts.KeepRecent {
+		return true
+	}
+	return opts.KeepEvery > 0 && version%opts.KeepEvery == 0
+}
@@ -8,7 +8,6 @@ import (
 
 	amino "github.com/tendermint/go-amino"
 	cmn "github.com/tendermint/iavl/common"
-	"github.com/tendermint/tendermint/crypto/tmhash"
 )
 
 var (
@@ -54,8 +53,14 @@ func (pin proofInnerNode) stringIndented(indent string) string {
 		indent)
 }
 
+// Hash returns the SHA-256 hash of the inner node, given the hash of the
+// child on the path.
 func (pin proofInnerNode) Hash(childHash []byte) []byte {
-	hasher := tmhash.New()
+	return pin.hash(SHA256, childHash)
+}
+
+func (pin proofInnerNode) hash(h Hasher, childHash []byte) []byte {
+	hasher := h.New()
 	buf := new(bytes.Buffer)
 
 	err := amino.EncodeInt8(buf, pin.Height)
@@ -118,8 +123,13 @@ func (pln proofLeafNode) stringIndented(indent string) string {
 		indent)
 }
 
+// Hash returns the SHA-256 hash of the leaf node.
 func (pln proofLeafNode) Hash() []byte {
-	hasher := tmhash.New()
+	return pln.hash(SHA256)
+}
+
+func (pln proofLeafNode) hash(h Hasher) []byte {
+	hasher := h.New()
 	buf := new(bytes.Buffer)
 
 	err := amino.EncodeInt8(buf, 0)
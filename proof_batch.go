@@ -0,0 +1,302 @@
+package iavl
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// BatchProof proves the presence or absence of any number of keys at once.
+// It holds the part of the tree that contains the leaves of the present keys
+// and the neighbors of the absent keys, so that inner nodes shared by
+// several keys appear only once.
+type BatchProof struct {
+	// Nodes are in post-order. Each inner node takes its children from the
+	// preceding nodes, except those whose hash it holds in Left or Right.
+	Nodes []BatchProofNode `json:"nodes"`
+
+	items  []batchProofItem // Set by Verify, nil until the root is verified.
+	hasher Hasher
+}
+
+// BatchProofNode is a node of a BatchProof, either a leaf or an inner node.
+type BatchProofNode struct {
+	Leaf  *proofLeafNode  `json:"leaf,omitempty"`
+	Inner *proofInnerNode `json:"inner,omitempty"`
+}
+
+// batchProofItem is a leaf of the proof, or a subtree that is only proven by
+// its hash, in key order.
+type batchProofItem struct {
+	leaf *proofLeafNode
+	hash []byte // The hash of the subtree, if leaf is nil.
+}
+
+// GetBatchProof returns a proof of the presence or absence of each key.
+func (t *ImmutableTree) GetBatchProof(keys [][]byte) (*BatchProof, error) {
+	if len(keys) == 0 {
+		return nil, errors.Wrap(ErrInvalidInputs, "no keys to prove")
+	}
+	if t.root == nil {
+		return nil, errors.New("cannot create proofs for an empty tree")
+	}
+	hasher := t.hasher()
+	t.root.hashWithCount(hasher) // Ensure that all hashes are calculated.
+
+	// The leaves of present keys prove their presence, and the leaves on
+	// both sides of absent keys prove their absence.
+	indexes := map[int64]struct{}{}
+	for _, key := range keys {
+		index, value := t.GetWithIndex(key)
+		if value != nil {
+			indexes[index] = struct{}{}
+			continue
+		}
+		if index > 0 {
+			indexes[index-1] = struct{}{}
+		}
+		if index < t.Size() {
+			indexes[index] = struct{}{}
+		}
+	}
+	sorted := make([]int64, 0, len(indexes))
+	for index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	proof := &BatchProof{hasher: hasher}
+	proof.addNode(t, t.root, 0, func(node *Node, offset int64) bool {
+		// Subtrees with none of the indexes are opaque.
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i] >= offset })
+		return i == len(sorted) || sorted[i] >= offset+node.size
+	})
+	return proof, nil
+}
+
+// addNode adds the subtree of the node, whose first leaf has the given index,
+// unless opaque returns true for it, in which case it returns true and its
+// parent holds its hash instead.
+func (proof *BatchProof) addNode(t *ImmutableTree, node *Node, offset int64, opaque func(node *Node, offset int64) bool) bool {
+	if opaque(node, offset) {
+		return true
+	}
+	if node.isLeaf() {
+		proof.Nodes = append(proof.Nodes, BatchProofNode{Leaf: &proofLeafNode{
+			Key:       node.key,
+			ValueHash: hashBytes(proof.hasher, node.value),
+			Version:   node.version,
+		}})
+		return false
+	}
+
+	left, right := node.getLeftNode(t), node.getRightNode(t)
+	inner := &proofInnerNode{
+		Height:  node.height,
+		Size:    node.size,
+		Version: node.version,
+	}
+	if proof.addNode(t, left, offset, opaque) {
+		inner.Left = left.hash
+	}
+	if proof.addNode(t, right, offset+left.size, opaque) {
+		inner.Right = right.hash
+	}
+	proof.Nodes = append(proof.Nodes, BatchProofNode{Inner: inner})
+	return false
+}
+
+// SetHasher sets the hash function of the tree the proof is for. Proofs
+// built by a tree use its hasher, but decoded proofs use SHA256 until it is
+// set.
+func (proof *BatchProof) SetHasher(hasher Hasher) {
+	proof.hasher = hasher
+	proof.items = nil
+}
+
+func (proof *BatchProof) getHasher() Hasher {
+	if proof.hasher == nil {
+		return SHA256
+	}
+	return proof.hasher
+}
+
+// Keys returns the keys of the leaves in the proof, which include the
+// neighbors of absent keys.
+func (proof *BatchProof) Keys() (keys [][]byte) {
+	if proof == nil {
+		return nil
+	}
+	for _, node := range proof.Nodes {
+		if node.Leaf != nil {
+			keys = append(keys, node.Leaf.Key)
+		}
+	}
+	return keys
+}
+
+// Verify checks that the proof is valid for the given root hash. It must be
+// called before VerifyItem and VerifyAbsence.
+func (proof *BatchProof) Verify(root []byte) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	proof.items = nil
+	rootHash, items, err := proof.computeRootHash(false)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(rootHash, root) {
+		return errors.Wrap(ErrInvalidRoot, "root hash doesn't match")
+	}
+	proof.items = items
+	return nil
+}
+
+// computeRootHash hashes the nodes in a single pass, and returns the root
+// hash and the items in key order. Unless opaqueInners is true, every inner
+// node must have a child in the proof, as those of GetBatchProof do. The
+// height and size of inner nodes are checked against their children, so that
+// no other preimage, such as that of a leaf, can pose as an inner node.
+func (proof *BatchProof) computeRootHash(opaqueInners bool) ([]byte, []batchProofItem, error) {
+	type subtree struct {
+		hash   []byte
+		items  []batchProofItem
+		height int8
+		size   int64 // Zero if the subtree is only known by its hash.
+	}
+	hasher := proof.getHasher()
+	hashSize := hasher.New().Size()
+	stack := []subtree{}
+	var lastKey []byte
+	for i, node := range proof.Nodes {
+		switch {
+		case node.Leaf != nil && node.Inner == nil:
+			leaf := node.Leaf
+			if lastKey != nil && bytes.Compare(lastKey, leaf.Key) >= 0 {
+				return nil, nil, errors.Wrapf(ErrInvalidProof, "leaf %d is not in key order", i)
+			}
+			lastKey = leaf.Key
+			stack = append(stack, subtree{hash: leaf.hash(hasher), items: []batchProofItem{{leaf: leaf}}, size: 1})
+
+		case node.Inner != nil && node.Leaf == nil:
+			inner := *node.Inner
+			var left, right subtree
+			if !opaqueInners && len(inner.Left) > 0 && len(inner.Right) > 0 {
+				return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d has no children in the proof", i)
+			}
+			if inner.Height < 1 || inner.Size < 2 {
+				return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d has height %d and size %d",
+					i, inner.Height, inner.Size)
+			}
+			for _, hash := range [][]byte{inner.Left, inner.Right} {
+				if len(hash) > 0 && len(hash) != hashSize {
+					return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d has a child hash of %d bytes, expected %d",
+						i, len(hash), hashSize)
+				}
+			}
+			if len(inner.Right) > 0 {
+				right = subtree{hash: inner.Right, items: []batchProofItem{{hash: inner.Right}}}
+			} else {
+				if len(stack) == 0 {
+					return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d is missing its right child", i)
+				}
+				right, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+			if len(inner.Left) > 0 {
+				left = subtree{hash: inner.Left, items: []batchProofItem{{hash: inner.Left}}}
+			} else {
+				if len(stack) == 0 {
+					return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d is missing its left child", i)
+				}
+				left, stack = stack[len(stack)-1], stack[:len(stack)-1]
+			}
+			if left.size > 0 && right.size > 0 {
+				if inner.Height != maxInt8(left.height, right.height)+1 {
+					return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d has height %d, but its children have heights %d and %d",
+						i, inner.Height, left.height, right.height)
+				}
+				if inner.Size != left.size+right.size {
+					return nil, nil, errors.Wrapf(ErrInvalidProof, "inner node %d has size %d, but its children have sizes %d and %d",
+						i, inner.Size, left.size, right.size)
+				}
+			}
+			inner.Left, inner.Right = left.hash, right.hash
+			stack = append(stack, subtree{
+				// The left hash is set, so this hashes the right child.
+				hash:   inner.hash(hasher, right.hash),
+				items:  append(left.items, right.items...),
+				height: inner.Height,
+				size:   inner.Size,
+			})
+
+		default:
+			return nil, nil, errors.Wrapf(ErrInvalidProof, "node %d must be either a leaf or an inner node", i)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, nil, errors.Wrapf(ErrInvalidProof, "proof has %d roots", len(stack))
+	}
+	return stack[0].hash, stack[0].items, nil
+}
+
+// VerifyItem checks that the proof proves that the key has the value. Verify
+// must be called first.
+func (proof *BatchProof) VerifyItem(key, value []byte) error {
+	leaf, _, err := proof.find(key)
+	if err != nil {
+		return err
+	}
+	if leaf == nil {
+		return errors.Wrap(ErrInvalidProof, "leaf key not found in proof")
+	}
+	if !bytes.Equal(leaf.ValueHash, hashBytes(proof.getHasher(), value)) {
+		return errors.Wrap(ErrInvalidProof, "leaf value hash not same")
+	}
+	return nil
+}
+
+// VerifyAbsence checks that the proof proves that the key is absent. Verify
+// must be called first.
+func (proof *BatchProof) VerifyAbsence(key []byte) error {
+	leaf, i, err := proof.find(key)
+	if err != nil {
+		return err
+	}
+	if leaf != nil {
+		return errors.Wrap(ErrInvalidProof, "absence disproved by leaf in proof")
+	}
+	// The key is absent if the leaves around it are adjacent in the tree,
+	// i.e. if no subtree between them is only proven by its hash.
+	if i < len(proof.items) && proof.items[i].leaf == nil {
+		return errors.Wrap(ErrInvalidProof, "absence not proved by adjacent leaves")
+	}
+	return nil
+}
+
+// find returns the leaf with the key if there is one, and otherwise the
+// position of the first item after the last leaf before the key.
+func (proof *BatchProof) find(key []byte) (*proofLeafNode, int, error) {
+	if proof == nil {
+		return nil, 0, errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if proof.items == nil {
+		return nil, 0, errors.New("must call Verify(root) first")
+	}
+	position := 0
+	for i, item := range proof.items {
+		if item.leaf == nil {
+			continue
+		}
+		switch bytes.Compare(item.leaf.Key, key) {
+		case 0:
+			return item.leaf, i, nil
+		case -1:
+			position = i + 1
+		default:
+			return nil, position, nil
+		}
+	}
+	return nil, position, nil
+}
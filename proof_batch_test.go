@@ -0,0 +1,183 @@
+package iavl
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestGetBatchProof(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 500; i++ {
+		tree.Set([]byte{byte(i >> 8), byte(i) &^ 1}, []byte(cmn.RandStr(8)))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	// Present keys, absent keys between keys, and absent keys before and after
+	// all keys.
+	present := [][]byte{{0x00, 0x00}, {0x00, 0x10}, {0x00, 0x12}, {0x01, 0x80}, {0x01, 0xf2}}
+	absent := [][]byte{{}, {0x00, 0x11}, {0x01, 0x81}, {0x02}}
+	proof, err := tree.GetBatchProof(append(append([][]byte{}, present...), absent...))
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify(root))
+	for _, key := range present {
+		value := tree.Get(key)
+		require.NoError(t, proof.VerifyItem(key, value))
+		require.Error(t, proof.VerifyItem(key, append(value, 'x')))
+		require.Error(t, proof.VerifyAbsence(key))
+	}
+	for _, key := range absent {
+		require.NoError(t, proof.VerifyAbsence(key), "key %X", key)
+		require.Error(t, proof.VerifyItem(key, nil))
+	}
+
+	// Keys that are not covered by the proof can't be proven either way.
+	value := tree.Get([]byte{0x00, 0x40})
+	require.Error(t, proof.VerifyItem([]byte{0x00, 0x40}, value))
+	require.Error(t, proof.VerifyAbsence([]byte{0x00, 0x41}))
+
+	// Shared inner nodes appear only once, so the batch proof is smaller than
+	// the range proofs of the keys.
+	inners, paths := 0, 0
+	for _, node := range proof.Nodes {
+		if node.Inner != nil {
+			inners++
+		}
+	}
+	for _, key := range append(present, absent...) {
+		_, rangeProof, err := tree.GetWithProof(key)
+		require.NoError(t, err)
+		paths += len(rangeProof.LeftPath)
+	}
+	require.True(t, inners < paths, "%d inner nodes, %d in range proofs", inners, paths)
+
+	// A single key in a single leaf tree.
+	single := NewMutableTree(db.NewMemDB(), 0)
+	single.Set([]byte("a"), []byte("1"))
+	_, _, err = single.SaveVersion()
+	require.NoError(t, err)
+	proof, err = single.GetBatchProof([][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, err)
+	require.Len(t, proof.Nodes, 1)
+	require.NoError(t, proof.Verify(single.Hash()))
+	require.NoError(t, proof.VerifyItem([]byte("a"), []byte("1")))
+	require.NoError(t, proof.VerifyAbsence([]byte("b")))
+}
+
+func TestBatchProofInvalid(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 100; i++ {
+		tree.Set(i2b(i*2), i2b(i))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	_, err = tree.GetBatchProof(nil)
+	require.Error(t, err)
+
+	proof, err := tree.GetBatchProof([][]byte{i2b(10), i2b(51), i2b(150)})
+	require.NoError(t, err)
+	require.Error(t, proof.VerifyAbsence(i2b(51)), "must verify the root first")
+	require.Error(t, proof.Verify([]byte("wrong root")))
+
+	// Tampering with any node invalidates the proof.
+	for i := range proof.Nodes {
+		node := proof.Nodes[i]
+		if node.Leaf != nil {
+			leaf := *node.Leaf
+			leaf.Version++
+			proof.Nodes[i] = BatchProofNode{Leaf: &leaf}
+		} else {
+			inner := *node.Inner
+			inner.Size++
+			proof.Nodes[i] = BatchProofNode{Inner: &inner}
+		}
+		require.Error(t, proof.Verify(root), "node %d", i)
+		proof.Nodes[i] = node
+	}
+	require.NoError(t, proof.Verify(root))
+
+	// A proof that fails to verify is no longer verified.
+	leaf := proof.Nodes[0].Leaf
+	value := tree.Get(leaf.Key)
+	require.NoError(t, proof.VerifyItem(leaf.Key, value))
+	proof.Nodes = proof.Nodes[1:]
+	require.Error(t, proof.Verify(root))
+	require.Error(t, proof.VerifyItem(leaf.Key, value))
+
+	// Decoded proofs must be verified, whatever the encoding says.
+	proof, err = tree.GetBatchProof([][]byte{i2b(10), i2b(51), i2b(150)})
+	require.NoError(t, err)
+	require.NoError(t, proof.Verify(root))
+	bz, err := json.Marshal(proof)
+	require.NoError(t, err)
+	bz = append(bz[:len(bz)-1], []byte(`,"RootVerified":true,"items":[]}`)...)
+	decoded := &BatchProof{}
+	require.NoError(t, json.Unmarshal(bz, decoded))
+	require.Error(t, decoded.VerifyAbsence(i2b(51)))
+	require.NoError(t, decoded.Verify(root))
+	require.NoError(t, decoded.VerifyAbsence(i2b(51)))
+
+	// Nodes that are dropped or out of order are rejected.
+	dropped := &BatchProof{Nodes: proof.Nodes[1:]}
+	require.Error(t, dropped.Verify(root))
+	n := len(proof.Nodes)
+	swapped := &BatchProof{Nodes: append(append([]BatchProofNode{}, proof.Nodes[:n-2]...), proof.Nodes[n-1], proof.Nodes[n-2])}
+	require.Error(t, swapped.Verify(root))
+	require.Error(t, (&BatchProof{Nodes: []BatchProofNode{{}}}).Verify(root))
+
+	// An inner node must have a child in the proof, even though one that
+	// only holds the hashes of its children hashes to the root.
+	opaque := &BatchProof{Nodes: []BatchProofNode{{Inner: &proofInnerNode{
+		Height:  tree.root.height,
+		Size:    tree.root.size,
+		Version: tree.root.version,
+		Left:    tree.root.leftHash,
+		Right:   tree.root.rightHash,
+	}}}}
+	require.Error(t, opaque.Verify(root))
+	rootHash, items, err := opaque.computeRootHash(true)
+	require.NoError(t, err)
+	require.Equal(t, root, rootHash)
+	require.Equal(t, []batchProofItem{{hash: tree.root.leftHash}, {hash: tree.root.rightHash}}, items)
+}
+
+func TestBatchProofInvalidInner(t *testing.T) {
+	hash := hashBytes(SHA256, []byte("child"))
+	leaves := []BatchProofNode{
+		{Leaf: &proofLeafNode{Key: []byte("a"), ValueHash: hash, Version: 1}},
+		{Leaf: &proofLeafNode{Key: []byte("b"), ValueHash: hash, Version: 1}},
+	}
+	testCases := map[string]struct {
+		nodes []BatchProofNode
+		valid bool
+	}{
+		"opaque":           {[]BatchProofNode{{Inner: &proofInnerNode{Height: 1, Size: 2, Left: hash, Right: hash}}}, true},
+		"leaf children":    {append(leaves, BatchProofNode{Inner: &proofInnerNode{Height: 1, Size: 2}}), true},
+		"zero height":      {[]BatchProofNode{{Inner: &proofInnerNode{Height: 0, Size: 2, Left: hash, Right: hash}}}, false},
+		"size one":         {[]BatchProofNode{{Inner: &proofInnerNode{Height: 1, Size: 1, Left: hash, Right: hash}}}, false},
+		"wrong height":     {append(leaves, BatchProofNode{Inner: &proofInnerNode{Height: 2, Size: 2}}), false},
+		"wrong size":       {append(leaves, BatchProofNode{Inner: &proofInnerNode{Height: 1, Size: 3}}), false},
+		"short left hash":  {[]BatchProofNode{{Inner: &proofInnerNode{Height: 1, Size: 2, Left: hash[1:], Right: hash}}}, false},
+		"long right hash":  {[]BatchProofNode{{Inner: &proofInnerNode{Height: 1, Size: 2, Left: hash, Right: append(hash, 0)}}}, false},
+		"short right hash": {append(leaves[:1:1], BatchProofNode{Inner: &proofInnerNode{Height: 1, Size: 2, Right: hash[1:]}}), false},
+	}
+	for name, tc := range testCases {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			proof := &BatchProof{Nodes: tc.nodes}
+			_, _, err := proof.computeRootHash(true)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// ChangeProof proves the changes between two versions of a tree, to a client
+// that trusts the root hashes of both. It holds the part of each tree that is
+// not shared with the other, down to the leaves of the changed keys. The
+// shared subtrees are proven only by their hashes, which must be the same in
+// key order in both trees, so the keys they hold are unchanged.
+type ChangeProof struct {
+	From *BatchProof `json:"from"`
+	To   *BatchProof `json:"to"`
+}
+
+// GetChangeProof returns the changes from the tree to the other tree, ordered
+// by key as by MutableTree.Diff, with a proof that can be verified with
+// ChangeProof.Verify. Both trees must use the same node database.
+func (t *ImmutableTree) GetChangeProof(to *ImmutableTree) ([]*Change, *ChangeProof, error) {
+	if to == nil || t.ndb != to.ndb {
+		return nil, nil, errors.Wrap(ErrInvalidInputs, "trees must use the same node database")
+	}
+	hasher := t.hasher()
+	// Ensure that all hashes are calculated.
+	if t.root != nil {
+		t.root.hashWithCount(hasher)
+	}
+	if to.root != nil {
+		to.root.hashWithCount(hasher)
+	}
+
+	shared := map[string]bool{}
+	changes := diffTrees(t, to, func(node *Node) {
+		shared[string(node.hash)] = true
+	})
+	proof := &ChangeProof{
+		From: t.getChangeProofSide(hasher, shared),
+		To:   to.getChangeProofSide(hasher, shared),
+	}
+	return changes, proof, nil
+}
+
+// getChangeProofSide returns the part of the tree outside the shared subtrees.
+// A subtree can only be in a tree once, so its hash identifies it.
+func (t *ImmutableTree) getChangeProofSide(hasher Hasher, shared map[string]bool) *BatchProof {
+	proof := &BatchProof{hasher: hasher}
+	if t.root != nil {
+		proof.addNode(t, t.root, 0, func(node *Node, _ int64) bool {
+			return shared[string(node.hash)]
+		})
+	}
+	return proof
+}
+
+// DiffWithProof returns the changes between fromVersion and toVersion, with a
+// proof of them.
+func (tree *MutableTree) DiffWithProof(fromVersion, toVersion int64) ([]*Change, *ChangeProof, error) {
+	from, err := tree.GetImmutable(fromVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := tree.GetImmutable(toVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+	return from.GetChangeProof(to)
+}
+
+// SetHasher sets the hash function of the tree the proof is for. Proofs
+// built by a tree use its hasher, but decoded proofs use SHA256 until it is
+// set.
+func (proof *ChangeProof) SetHasher(hasher Hasher) {
+	if proof.From != nil {
+		proof.From.SetHasher(hasher)
+	}
+	if proof.To != nil {
+		proof.To.SetHasher(hasher)
+	}
+}
+
+// Verify verifies that the changes, ordered by key, are exactly the changes
+// from the tree with root hash fromRoot to the tree with root hash toRoot. A
+// nil root is an empty tree. OldValue is only checked for updated and removed
+// keys, and NewValue for added and updated keys.
+func (proof *ChangeProof) Verify(fromRoot, toRoot []byte, changes []*Change) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	fromItems, err := verifyChangeProofSide(proof.From, fromRoot)
+	if err != nil {
+		return errors.Wrap(err, "verifying from tree")
+	}
+	toItems, err := verifyChangeProofSide(proof.To, toRoot)
+	if err != nil {
+		return errors.Wrap(err, "verifying to tree")
+	}
+
+	// The trees are the shared subtrees and the leaves of each proof, so if
+	// the shared subtrees are the same the changes are in the leaves.
+	fromShared, fromLeaves := splitBatchProofItems(fromItems)
+	toShared, toLeaves := splitBatchProofItems(toItems)
+	if len(fromShared) != len(toShared) {
+		return errors.Wrapf(ErrInvalidProof, "trees have %d and %d shared subtrees", len(fromShared), len(toShared))
+	}
+	for i := range fromShared {
+		if !bytes.Equal(fromShared[i], toShared[i]) {
+			return errors.Wrapf(ErrInvalidProof, "shared subtree %d differs", i)
+		}
+	}
+
+	hasher := proof.To.getHasher()
+	next := 0
+	for len(fromLeaves) > 0 || len(toLeaves) > 0 {
+		var from, to *proofLeafNode
+		switch {
+		case len(toLeaves) == 0 || (len(fromLeaves) > 0 && bytes.Compare(fromLeaves[0].Key, toLeaves[0].Key) < 0):
+			from, fromLeaves = fromLeaves[0], fromLeaves[1:]
+		case len(fromLeaves) == 0 || bytes.Compare(fromLeaves[0].Key, toLeaves[0].Key) > 0:
+			to, toLeaves = toLeaves[0], toLeaves[1:]
+		default:
+			from, fromLeaves = fromLeaves[0], fromLeaves[1:]
+			to, toLeaves = toLeaves[0], toLeaves[1:]
+			if bytes.Equal(from.ValueHash, to.ValueHash) {
+				continue
+			}
+		}
+
+		if next == len(changes) {
+			return errors.Wrap(ErrInvalidProof, "changes are missing")
+		}
+		change := changes[next]
+		next++
+		if change == nil {
+			return errors.Wrapf(ErrInvalidInputs, "change %d is nil", next-1)
+		}
+		if err := verifyChange(hasher, change, from, to); err != nil {
+			return errors.Wrapf(err, "change of key %X", change.Key)
+		}
+	}
+	if next != len(changes) {
+		return errors.Wrapf(ErrInvalidProof, "%d changes are not in the proof", len(changes)-next)
+	}
+	return nil
+}
+
+// verifyChangeProofSide verifies a side of a change proof against its root,
+// and returns its items. A side without nodes is either an empty tree, or a
+// tree that is shared as a whole. Unlike in batch proofs, an inner node may
+// have no children in the proof, when it joins two shared subtrees.
+func verifyChangeProofSide(side *BatchProof, root []byte) ([]batchProofItem, error) {
+	if side == nil {
+		return nil, errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if len(side.Nodes) == 0 {
+		if root == nil {
+			return nil, nil
+		}
+		return []batchProofItem{{hash: root}}, nil
+	}
+	rootHash, items, err := side.computeRootHash(true)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(rootHash, root) {
+		return nil, errors.Wrap(ErrInvalidRoot, "root hash doesn't match")
+	}
+	return items, nil
+}
+
+// splitBatchProofItems returns the hashes of the subtrees and the leaves of
+// the items, in key order.
+func splitBatchProofItems(items []batchProofItem) (hashes [][]byte, leaves []*proofLeafNode) {
+	for _, item := range items {
+		if item.leaf != nil {
+			leaves = append(leaves, item.leaf)
+		} else {
+			hashes = append(hashes, item.hash)
+		}
+	}
+	return hashes, leaves
+}
+
+// verifyChange verifies that the change takes the key from the leaf of the
+// from tree to the leaf of the to tree, either of which is nil if the key is
+// not in that tree.
+func verifyChange(hasher Hasher, change *Change, from, to *proofLeafNode) error {
+	leaf, changeType := from, ChangeUpdate
+	switch {
+	case from == nil:
+		leaf, changeType = to, ChangeAdd
+	case to == nil:
+		changeType = ChangeRemove
+	}
+	if !bytes.Equal(change.Key, leaf.Key) {
+		return errors.Wrapf(ErrInvalidProof, "proof changes key %X instead", leaf.Key)
+	}
+	if change.Type != changeType {
+		return errors.Wrapf(ErrInvalidProof, "change is %v, not %v", changeType, change.Type)
+	}
+	if from != nil && !bytes.Equal(from.ValueHash, hashBytes(hasher, change.OldValue)) {
+		return errors.Wrap(ErrInvalidProof, "old value hash not same")
+	}
+	if to != nil && !bytes.Equal(to.ValueHash, hashBytes(hasher, change.NewValue)) {
+		return errors.Wrap(ErrInvalidProof, "new value hash not same")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestDiffWithProof(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	roots := map[int64][]byte{}
+	for v := int64(1); v <= 6; v++ {
+		for i := 0; i < 50; i++ {
+			tree.Set([]byte(cmn.RandStr(2)), []byte(cmn.RandStr(2)))
+		}
+		for i := 0; i < 20; i++ {
+			tree.Remove([]byte(cmn.RandStr(2)))
+		}
+		if v == 3 {
+			// Empty version.
+			keys := [][]byte{}
+			tree.Iterate(func(key, value []byte) bool {
+				keys = append(keys, key)
+				return false
+			})
+			for _, key := range keys {
+				tree.Remove(key)
+			}
+		}
+		hash, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+		roots[v] = hash
+	}
+
+	for from := int64(1); from <= 6; from++ {
+		for to := int64(1); to <= 6; to++ {
+			changes, proof, err := tree.DiffWithProof(from, to)
+			require.NoError(t, err)
+			expected, err := tree.Diff(from, to)
+			require.NoError(t, err)
+			require.Equal(t, expected, changes)
+			require.NoError(t, proof.Verify(roots[from], roots[to], changes), "from %v to %v", from, to)
+
+			require.Error(t, proof.Verify([]byte("wrong root"), roots[to], changes))
+			require.Error(t, proof.Verify(roots[from], []byte("wrong root"), changes))
+			if len(changes) > 0 {
+				require.Error(t, proof.Verify(roots[from], roots[to], changes[1:]))
+				require.Error(t, proof.Verify(roots[from], roots[to], append(changes, changes[0])))
+				changed := *changes[0]
+				changed.Key = append(changed.Key, 'x')
+				require.Error(t, proof.Verify(roots[from], roots[to], append([]*Change{&changed}, changes[1:]...)))
+				changed = *changes[0]
+				changed.NewValue = append(changed.NewValue, 'x')
+				changed.OldValue = append(changed.OldValue, 'x')
+				require.Error(t, proof.Verify(roots[from], roots[to], append([]*Change{&changed}, changes[1:]...)))
+			}
+		}
+	}
+
+	_, _, err := tree.DiffWithProof(1, 7)
+	require.Error(t, err)
+}
+
+func TestChangeProofShared(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 1000; i++ {
+		tree.Set(indexKey(i), []byte(cmn.RandStr(8)))
+	}
+	from, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	tree.Set(indexKey(500), []byte("new value"))
+	tree.Remove(indexKey(700))
+	to, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+
+	changes, proof, err := tree.DiffWithProof(1, 2)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.NoError(t, proof.Verify(from, to, changes))
+	// Only the paths to the changed keys are in the proof.
+	require.True(t, len(proof.From.Nodes) < 50, "%d nodes", len(proof.From.Nodes))
+	require.True(t, len(proof.To.Nodes) < 50, "%d nodes", len(proof.To.Nodes))
+
+	// Hiding a change in a subtree that isn't shared is rejected.
+	forged := &ChangeProof{From: proof.From, To: &BatchProof{}}
+	require.Error(t, forged.Verify(from, to, changes))
+	require.Error(t, forged.Verify(from, from, nil))
+
+	// Trees from different databases can't be compared.
+	v1, err := tree.GetImmutable(1)
+	require.NoError(t, err)
+	_, _, err = v1.GetChangeProof(NewMutableTree(db.NewMemDB(), 0).ImmutableTree)
+	require.Error(t, err)
+}
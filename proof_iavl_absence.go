@@ -42,7 +42,7 @@ func IAVLAbsenceOpDecoder(pop merkle.ProofOp) (merkle.ProofOperator, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "decoding ProofOp.Data into IAVLAbsenceOp")
 	}
-	return NewIAVLAbsenceOp(pop.Key, op.Proof), nil
+	return NewIAVLAbsenceOp(pop.Key, op.Proof.unverified()), nil
 }
 
 func (op IAVLAbsenceOp) ProofOp() merkle.ProofOp {
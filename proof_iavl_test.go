@@ -0,0 +1,45 @@
+package iavl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tendermint/tendermint/crypto/merkle"
+	db "github.com/tendermint/tm-db"
+)
+
+func TestProofOps(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for _, key := range []string{"a", "c", "e", "g"} {
+		tree.Set([]byte(key), []byte("value of "+key))
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+	prt := DefaultProofRuntime()
+	keyPath := func(key string) string {
+		return merkle.KeyPath{}.AppendKey([]byte(key), merkle.KeyEncodingURL).String()
+	}
+
+	value, proof, err := tree.GetWithProof([]byte("c"))
+	require.NoError(t, err)
+	proofOps := &merkle.Proof{Ops: []merkle.ProofOp{NewIAVLValueOp([]byte("c"), proof).ProofOp()}}
+	require.NoError(t, prt.VerifyValue(proofOps, root, keyPath("c"), value))
+	require.Error(t, prt.VerifyValue(proofOps, root, keyPath("c"), []byte("other")))
+	require.Error(t, prt.VerifyValue(proofOps, []byte("other root"), keyPath("c"), value))
+
+	_, proof, err = tree.GetWithProof([]byte("d"))
+	require.NoError(t, err)
+	proofOps = &merkle.Proof{Ops: []merkle.ProofOp{NewIAVLAbsenceOp([]byte("d"), proof).ProofOp()}}
+	require.NoError(t, prt.VerifyAbsence(proofOps, root, keyPath("d")))
+	require.Error(t, prt.VerifyAbsence(proofOps, root, keyPath("c")))
+
+	// The decoders reject other operators, and don't trust memoized values.
+	_, err = IAVLValueOpDecoder(proofOps.Ops[0])
+	require.Error(t, err)
+	proof.RootHash = []byte("forged")
+	decoded, err := IAVLAbsenceOpDecoder(NewIAVLAbsenceOp([]byte("d"), proof).ProofOp())
+	require.NoError(t, err)
+	require.Nil(t, decoded.(IAVLAbsenceOp).Proof.RootHash)
+	require.False(t, decoded.(IAVLAbsenceOp).Proof.RootVerified)
+}
@@ -43,7 +43,7 @@ func IAVLValueOpDecoder(pop merkle.ProofOp) (merkle.ProofOperator, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "decoding ProofOp.Data into IAVLValueOp")
 	}
-	return NewIAVLValueOp(pop.Key, op.Proof), nil
+	return NewIAVLValueOp(pop.Key, op.Proof.unverified()), nil
 }
 
 func (op IAVLValueOp) ProofOp() merkle.ProofOp {
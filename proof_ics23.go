@@ -0,0 +1,156 @@
+package iavl
+
+import (
+	"encoding/binary"
+
+	ics23 "github.com/confio/ics23/go"
+	"github.com/pkg/errors"
+)
+
+// GetMembershipProof returns an ICS23 proof that the key exists in the tree,
+// which can be verified with VerifyMembership. Only trees hashed with SHA256
+// can be proven with ICS23 proofs.
+func (t *ImmutableTree) GetMembershipProof(key []byte) (*ics23.CommitmentProof, error) {
+	exist, err := t.createExistenceProof(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ics23.CommitmentProof{
+		Proof: &ics23.CommitmentProof_Exist{Exist: exist},
+	}, nil
+}
+
+// GetNonMembershipProof returns an ICS23 proof that the key does not exist in
+// the tree, which can be verified with VerifyNonMembership. It proves the
+// existence of the keys right before and after the key, if any.
+func (t *ImmutableTree) GetNonMembershipProof(key []byte) (*ics23.CommitmentProof, error) {
+	if err := t.checkICS23(); err != nil {
+		return nil, err
+	}
+	index, value := t.GetWithIndex(key)
+	if value != nil {
+		return nil, errors.Errorf("cannot create non-membership proof for existing key %X", key)
+	}
+
+	nonexist := &ics23.NonExistenceProof{Key: key}
+	var err error
+	if index > 0 {
+		leftKey, _ := t.GetByIndex(index - 1)
+		if nonexist.Left, err = t.createExistenceProof(leftKey); err != nil {
+			return nil, err
+		}
+	}
+	if index < t.Size() {
+		rightKey, _ := t.GetByIndex(index)
+		if nonexist.Right, err = t.createExistenceProof(rightKey); err != nil {
+			return nil, err
+		}
+	}
+	return &ics23.CommitmentProof{
+		Proof: &ics23.CommitmentProof_Nonexist{Nonexist: nonexist},
+	}, nil
+}
+
+// VerifyMembership verifies an ICS23 proof that the key has the value in the
+// tree with the given root hash, using the IAVL proof spec.
+func VerifyMembership(root []byte, proof *ics23.CommitmentProof, key, value []byte) error {
+	if !ics23.VerifyMembership(ics23.IavlSpec, root, proof, key, value) {
+		return errors.Wrap(ErrInvalidProof, "membership not proven")
+	}
+	return nil
+}
+
+// VerifyNonMembership verifies an ICS23 proof that the key does not exist in
+// the tree with the given root hash, using the IAVL proof spec.
+func VerifyNonMembership(root []byte, proof *ics23.CommitmentProof, key []byte) error {
+	if !ics23.VerifyNonMembership(ics23.IavlSpec, root, proof, key) {
+		return errors.Wrap(ErrInvalidProof, "non-membership not proven")
+	}
+	return nil
+}
+
+// checkICS23 returns an error if the tree can't be proven with ICS23 proofs.
+func (t *ImmutableTree) checkICS23() error {
+	if t.root == nil {
+		return errors.New("cannot create ICS23 proofs for an empty tree")
+	}
+	if t.hasher().Name() != SHA256.Name() {
+		return errors.Errorf("ICS23 proofs do not support hasher %q", t.hasher().Name())
+	}
+	return nil
+}
+
+// createExistenceProof converts the path to the key's leaf into an ICS23
+// existence proof.
+func (t *ImmutableTree) createExistenceProof(key []byte) (*ics23.ExistenceProof, error) {
+	if err := t.checkICS23(); err != nil {
+		return nil, err
+	}
+	t.root.hashWithCount(t.hasher()) // Ensure that all hashes are calculated.
+	path, leaf, err := t.root.PathToLeaf(t, key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create membership proof for key %X", key)
+	}
+	return &ics23.ExistenceProof{
+		Key:   leaf.key,
+		Value: leaf.value,
+		Leaf:  convertLeafOp(proofLeafNode{Key: leaf.key, Version: leaf.version}),
+		Path:  convertInnerOps(path),
+	}, nil
+}
+
+// convertLeafOp returns the ICS23 operation that hashes a leaf like
+// proofLeafNode.Hash, given the key and the value.
+func convertLeafOp(leaf proofLeafNode) *ics23.LeafOp {
+	prefix := appendVarint(nil, 0) // height
+	prefix = appendVarint(prefix, 1)
+	prefix = appendVarint(prefix, leaf.Version)
+	return &ics23.LeafOp{
+		Hash:         ics23.HashOp_SHA256,
+		PrehashValue: ics23.HashOp_SHA256,
+		Length:       ics23.LengthOp_VAR_PROTO,
+		Prefix:       prefix,
+	}
+}
+
+// convertInnerOps returns the ICS23 operations that hash the path like
+// proofInnerNode.Hash, from the leaf up to the root.
+func convertInnerOps(path PathToLeaf) []*ics23.InnerOp {
+	ops := make([]*ics23.InnerOp, 0, len(path))
+	for i := len(path) - 1; i >= 0; i-- {
+		pin := path[i]
+		prefix := appendVarint(nil, int64(pin.Height))
+		prefix = appendVarint(prefix, pin.Size)
+		prefix = appendVarint(prefix, pin.Version)
+
+		// Child hashes are length-prefixed.
+		var suffix []byte
+		if len(pin.Left) > 0 {
+			prefix = appendByteSlice(prefix, pin.Left)
+			prefix = append(prefix, hashSize)
+		} else {
+			prefix = append(prefix, hashSize)
+			suffix = appendByteSlice(nil, pin.Right)
+		}
+		ops = append(ops, &ics23.InnerOp{
+			Hash:   ics23.HashOp_SHA256,
+			Prefix: prefix,
+			Suffix: suffix,
+		})
+	}
+	return ops
+}
+
+// appendVarint appends the amino encoding of a signed varint.
+func appendVarint(bz []byte, i int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], i)
+	return append(bz, buf[:n]...)
+}
+
+// appendByteSlice appends the amino encoding of a byte slice.
+func appendByteSlice(bz []byte, slice []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(slice)))
+	return append(append(bz, buf[:n]...), slice...)
+}
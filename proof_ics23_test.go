@@ -0,0 +1,91 @@
+package iavl
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	ics23 "github.com/confio/ics23/go"
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func buildICS23Tree(t *testing.T, size int) (*ImmutableTree, [][]byte) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	keys := make([][]byte, 0, size)
+	for i := 0; i < size; i++ {
+		// Even bytes only, so that odd keys are known to be absent.
+		key := []byte{byte(cmn.RandInt()) &^ 1, byte(cmn.RandInt()) &^ 1, byte(cmn.RandInt()) &^ 1}
+		if !tree.Set(key, []byte(cmn.RandStr(8))) {
+			keys = append(keys, key)
+		}
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	return tree.ImmutableTree, keys
+}
+
+func TestGetMembershipProof(t *testing.T) {
+	for _, size := range []int{1, 2, 10, 200} {
+		tree, keys := buildICS23Tree(t, size)
+		root := tree.Hash()
+		for _, key := range keys {
+			value := tree.Get(key)
+			proof, err := tree.GetMembershipProof(key)
+			require.NoError(t, err)
+			require.NoError(t, VerifyMembership(root, proof, key, value))
+
+			calculated, err := proof.Calculate()
+			require.NoError(t, err)
+			require.Equal(t, root, []byte(calculated))
+
+			require.Error(t, VerifyMembership(root, proof, key, append(value, 'x')))
+			require.Error(t, VerifyNonMembership(root, proof, key))
+		}
+
+		_, err := tree.GetMembershipProof([]byte{1})
+		require.Error(t, err)
+	}
+}
+
+func TestGetNonMembershipProof(t *testing.T) {
+	for _, size := range []int{1, 2, 10, 200} {
+		tree, keys := buildICS23Tree(t, size)
+		root := tree.Hash()
+
+		// Before the first key, between all keys, and after the last key.
+		absent := [][]byte{{0x00}, {0x01}, {0xff, 0xff, 0xff}}
+		for _, key := range keys {
+			absent = append(absent, append(cpIncr(key), 0x01))
+		}
+		for _, key := range absent {
+			proof, err := tree.GetNonMembershipProof(key)
+			require.NoError(t, err)
+			require.NoError(t, VerifyNonMembership(root, proof, key), "key %X", key)
+
+			nonexist := proof.GetNonexist()
+			require.Error(t, VerifyNonMembership(root, proof, keys[0]))
+			if nonexist.Left != nil && nonexist.Right != nil {
+				// Dropping a neighbor doesn't prove anything.
+				partial := &ics23.CommitmentProof{Proof: &ics23.CommitmentProof_Nonexist{
+					Nonexist: &ics23.NonExistenceProof{Key: key, Left: nonexist.Left},
+				}}
+				require.Error(t, VerifyNonMembership(root, partial, key))
+			}
+		}
+
+		_, err := tree.GetNonMembershipProof(keys[0])
+		require.Error(t, err)
+	}
+}
+
+func TestICS23UnsupportedHasher(t *testing.T) {
+	tree := NewMutableTreeWithOpts(db.NewMemDB(), 0, &Options{Hasher: Blake2b256})
+	tree.Set([]byte("a"), []byte("1"))
+	_, err := tree.GetMembershipProof([]byte("a"))
+	require.Error(t, err)
+	_, err = tree.GetNonMembershipProof([]byte("b"))
+	require.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// GetByIndexWithProof gets the key and value at the given index, with a proof
+// that can be verified with RangeProof.VerifyItemAt.
+func (t *ImmutableTree) GetByIndexWithProof(index int64) (key, value []byte, proof *RangeProof, err error) {
+	if index < 0 || index >= t.Size() {
+		return nil, nil, nil, errors.Wrapf(ErrInvalidInputs, "index %d out of range", index)
+	}
+	key, value = t.GetByIndex(index)
+	proof, _, _, err = t.getRangeProof(key, cpIncr(key), 1)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "constructing range proof")
+	}
+	return key, value, proof, nil
+}
+
+// VerifyItemAt verifies that the key has the value, and is at the given index
+// of the tree, i.e. that index keys are before it. Does not assume that the
+// proof itself is valid, call Verify() first.
+func (proof *RangeProof) VerifyItemAt(index int64, key, value []byte) error {
+	if err := proof.VerifyItem(key, value); err != nil {
+		return err
+	}
+	if !bytes.Equal(proof.Leaves[0].Key, key) {
+		return errors.Wrap(ErrInvalidProof, "key is not the first leaf of the proof")
+	}
+	if leftIndex := proof.LeftIndex(); leftIndex != index {
+		return errors.Wrapf(ErrInvalidProof, "key is at index %d", leftIndex)
+	}
+	return nil
+}
+
+// RangeCountProof proves the number of keys in a range, by proving the
+// number of keys before each end of the range.
+type RangeCountProof struct {
+	// StartProof proves the position of the start of the range. It is nil
+	// if the range is open at the start or the tree is empty.
+	StartProof *RangeProof `json:"start_proof"`
+	// EndProof proves the position of the end of the range, or the size of
+	// the tree if the range is open at the end. It is nil if the tree is
+	// empty.
+	EndProof *RangeProof `json:"end_proof"`
+}
+
+// GetRangeCountWithProof returns the number of keys in [start, end), with a
+// proof that can be verified with RangeCountProof.Verify. Either end may be
+// nil for an open range.
+func (t *ImmutableTree) GetRangeCountWithProof(start, end []byte) (count int64, proof *RangeCountProof, err error) {
+	if start != nil && end != nil && bytes.Compare(start, end) > 0 {
+		return 0, nil, errors.Wrap(ErrInvalidInputs, "start must not be after end")
+	}
+	proof = &RangeCountProof{}
+	if t.root == nil {
+		return 0, proof, nil
+	}
+
+	startIndex, endIndex := int64(0), t.Size()
+	if start != nil {
+		startIndex, _ = t.GetWithIndex(start)
+		if proof.StartProof, _, _, err = t.getRangeProof(start, cpIncr(start), 2); err != nil {
+			return 0, nil, errors.Wrap(err, "constructing range proof")
+		}
+	}
+	if end != nil {
+		endIndex, _ = t.GetWithIndex(end)
+		proof.EndProof, _, _, err = t.getRangeProof(end, cpIncr(end), 2)
+	} else {
+		// Any proof proves the size of the tree.
+		proof.EndProof, _, _, err = t.getRangeProof(nil, nil, 1)
+	}
+	if err != nil {
+		return 0, nil, errors.Wrap(err, "constructing range proof")
+	}
+	return endIndex - startIndex, proof, nil
+}
+
+// Verify verifies that there are count keys in [start, end) in the tree with
+// the given root hash. Either end may be nil for an open range.
+func (proof *RangeCountProof) Verify(root []byte, start, end []byte, count int64) error {
+	if proof == nil {
+		return errors.Wrap(ErrInvalidProof, "proof is nil")
+	}
+	if root == nil {
+		if proof.StartProof != nil || proof.EndProof != nil || count != 0 {
+			return errors.Wrap(ErrInvalidProof, "an empty tree has no keys")
+		}
+		return nil
+	}
+
+	startIndex := int64(0)
+	if start != nil {
+		index, err := proof.StartProof.verifyRank(root, start)
+		if err != nil {
+			return errors.Wrap(err, "verifying start")
+		}
+		startIndex = index
+	}
+	endIndex, err := proof.EndProof.verifyRank(root, end)
+	if err != nil {
+		return errors.Wrap(err, "verifying end")
+	}
+	if endIndex-startIndex != count {
+		return errors.Wrapf(ErrInvalidProof, "range has %d keys, not %d", endIndex-startIndex, count)
+	}
+	return nil
+}
+
+// verifyRank verifies the proof against the root, and returns the number of
+// keys before the key, or the size of the tree if the key is nil. The proof
+// must be for GetWithProof(key), which proves the leaf of the key, or the
+// leaves before and after it.
+func (proof *RangeProof) verifyRank(root []byte, key []byte) (int64, error) {
+	// Never trust memoized values from the sender.
+	proof = proof.unverified()
+	if err := proof.Verify(root); err != nil {
+		return 0, err
+	}
+	if key == nil {
+		if len(proof.LeftPath) == 0 {
+			return 1, nil
+		}
+		return proof.LeftPath[0].Size, nil
+	}
+
+	index := proof.LeftIndex()
+	if index < 0 {
+		return 0, errors.Wrap(ErrInvalidProof, "left path has no index")
+	}
+	// Range proof inners only have right children, so that the leaves are
+	// adjacent in the tree.
+	for _, path := range proof.InnerNodes {
+		for _, pin := range path {
+			if len(pin.Left) > 0 {
+				return 0, errors.Wrap(ErrInvalidProof, "leaves are not adjacent")
+			}
+		}
+	}
+
+	leaves := proof.Leaves
+	switch bytes.Compare(leaves[0].Key, key) {
+	case 0:
+		return index, nil
+	case 1:
+		if index != 0 {
+			return 0, errors.Wrap(ErrInvalidProof, "first leaf is after the key, but is not the first of the tree")
+		}
+		return 0, nil
+	default:
+		if len(leaves) > 1 {
+			if bytes.Compare(leaves[1].Key, key) < 0 {
+				return 0, errors.Wrap(ErrInvalidProof, "second leaf is before the key")
+			}
+		} else if !proof.TreeEnd {
+			return 0, errors.Wrap(ErrInvalidProof, "last leaf is before the key, but is not the last of the tree")
+		}
+		return index + 1, nil
+	}
+}
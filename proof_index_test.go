@@ -0,0 +1,95 @@
+package iavl
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func indexKey(i int) []byte {
+	return []byte(fmt.Sprintf("k%03d", i))
+}
+
+func TestGetByIndexWithProof(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 100; i++ {
+		tree.Set(indexKey(i*2), []byte{byte(i)})
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	for i := int64(0); i < tree.Size(); i++ {
+		key, value, proof, err := tree.GetByIndexWithProof(i)
+		require.NoError(t, err)
+		require.Equal(t, indexKey(int(i)*2), key)
+		require.NoError(t, proof.Verify(root))
+		require.NoError(t, proof.VerifyItemAt(i, key, value))
+		require.Error(t, proof.VerifyItemAt(i+1, key, value))
+		require.Error(t, proof.VerifyItemAt(i, key, []byte("wrong value")))
+	}
+
+	_, _, _, err = tree.GetByIndexWithProof(-1)
+	require.Error(t, err)
+	_, _, _, err = tree.GetByIndexWithProof(tree.Size())
+	require.Error(t, err)
+}
+
+func TestGetRangeCountWithProof(t *testing.T) {
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	for i := 0; i < 100; i++ {
+		tree.Set(indexKey(i*2), []byte{byte(i)})
+	}
+	_, _, err := tree.SaveVersion()
+	require.NoError(t, err)
+	root := tree.Hash()
+
+	testCases := []struct {
+		start, end []byte
+		count      int64
+	}{
+		{nil, nil, 100},
+		{nil, indexKey(10), 5},
+		{indexKey(10), nil, 95},
+		{indexKey(10), indexKey(20), 5},
+		{indexKey(11), indexKey(21), 5},
+		{indexKey(11), indexKey(12), 0},
+		{indexKey(10), indexKey(10), 0},
+		{[]byte("a"), []byte("z"), 100},
+		{[]byte("z"), nil, 0},
+		{nil, []byte("a"), 0},
+	}
+	for _, tc := range testCases {
+		count, proof, err := tree.GetRangeCountWithProof(tc.start, tc.end)
+		require.NoError(t, err)
+		require.Equal(t, tc.count, count, "[%X, %X)", tc.start, tc.end)
+		require.NoError(t, proof.Verify(root, tc.start, tc.end, count), "[%X, %X)", tc.start, tc.end)
+		require.Error(t, proof.Verify(root, tc.start, tc.end, count+1))
+		require.Error(t, proof.Verify([]byte("wrong root"), tc.start, tc.end, count))
+	}
+
+	// Proofs of other bounds don't prove the count.
+	count, proof, err := tree.GetRangeCountWithProof(indexKey(10), indexKey(20))
+	require.NoError(t, err)
+	require.Error(t, proof.Verify(root, indexKey(30), indexKey(40), count))
+	require.Error(t, proof.Verify(root, nil, indexKey(20), count))
+
+	// Dropping the second leaf of a bound proof leaves the bound unproven.
+	_, proof, err = tree.GetRangeCountWithProof(indexKey(11), nil)
+	require.NoError(t, err)
+	proof.StartProof.Leaves = proof.StartProof.Leaves[:1]
+	proof.StartProof.InnerNodes = nil
+	require.Error(t, proof.Verify(root, indexKey(11), nil, 94))
+
+	_, _, err = NewMutableTree(db.NewMemDB(), 0).GetRangeCountWithProof(indexKey(2), indexKey(1))
+	require.Error(t, err)
+
+	// An empty tree has no keys.
+	empty := NewMutableTree(db.NewMemDB(), 0)
+	count, proof, err = empty.GetRangeCountWithProof(nil, nil)
+	require.NoError(t, err)
+	require.Zero(t, count)
+	require.NoError(t, proof.Verify(nil, nil, nil, 0))
+}
@@ -31,16 +31,16 @@ func (pwl pathWithLeaf) StringIndented(indent string) string {
 // `verify` checks that the leaf node's hash + the inner nodes merkle-izes to
 // the given root. If it returns an error, it means the leafHash or the
 // PathToLeaf is incorrect.
-func (pwl pathWithLeaf) verify(root []byte) error {
-	leafHash := pwl.Leaf.Hash()
-	return pwl.Path.verify(leafHash, root)
+func (pwl pathWithLeaf) verify(hasher Hasher, root []byte) error {
+	leafHash := pwl.Leaf.hash(hasher)
+	return pwl.Path.verify(hasher, leafHash, root)
 }
 
 // `computeRootHash` computes the root hash with leaf node.
 // Does not verify the root hash.
-func (pwl pathWithLeaf) computeRootHash() []byte {
-	leafHash := pwl.Leaf.Hash()
-	return pwl.Path.computeRootHash(leafHash)
+func (pwl pathWithLeaf) computeRootHash(hasher Hasher) []byte {
+	leafHash := pwl.Leaf.hash(hasher)
+	return pwl.Path.computeRootHash(hasher, leafHash)
 }
 
 //----------------------------------------
@@ -76,11 +76,11 @@ func (pl PathToLeaf) stringIndented(indent string) string {
 // `verify` checks that the leaf node's hash + the inner nodes merkle-izes to
 // the given root. If it returns an error, it means the leafHash or the
 // PathToLeaf is incorrect.
-func (pl PathToLeaf) verify(leafHash []byte, root []byte) error {
+func (pl PathToLeaf) verify(hasher Hasher, leafHash []byte, root []byte) error {
 	hash := leafHash
 	for i := len(pl) - 1; i >= 0; i-- {
 		pin := pl[i]
-		hash = pin.Hash(hash)
+		hash = pin.hash(hasher, hash)
 	}
 	if !bytes.Equal(root, hash) {
 		return errors.Wrap(ErrInvalidProof, "")
@@ -90,11 +90,11 @@ func (pl PathToLeaf) verify(leafHash []byte, root []byte) error {
 
 // `computeRootHash` computes the root hash assuming some leaf hash.
 // Does not verify the root hash.
-func (pl PathToLeaf) computeRootHash(leafHash []byte) []byte {
+func (pl PathToLeaf) computeRootHash(hasher Hasher, leafHash []byte) []byte {
 	hash := leafHash
 	for i := len(pl) - 1; i >= 0; i-- {
 		pin := pl[i]
-		hash = pin.Hash(hash)
+		hash = pin.hash(hasher, hash)
 	}
 	return hash
 }
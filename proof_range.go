@@ -7,8 +7,6 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
-
-	"github.com/tendermint/tendermint/crypto/tmhash"
 )
 
 type RangeProof struct {
@@ -23,6 +21,38 @@ type RangeProof struct {
 	RootHash     []byte // valid iff rootVerified is true
 	TreeEnd      bool   // valid iff rootVerified is true
 
+	// hasher is the hash function of the tree, see SetHasher.
+	hasher Hasher
+}
+
+// SetHasher sets the hash function of the tree the proof is for. Proofs
+// built by a tree use its hasher, but decoded proofs use SHA256 until it is
+// set.
+func (proof *RangeProof) SetHasher(hasher Hasher) {
+	proof.hasher = hasher
+	proof.RootVerified = false
+	proof.RootHash = nil
+}
+
+// unverified returns a copy of the proof without the memoized values, which
+// must never be trusted when they come from the sender of the proof.
+func (proof *RangeProof) unverified() *RangeProof {
+	if proof == nil {
+		return nil
+	}
+	return &RangeProof{
+		LeftPath:   proof.LeftPath,
+		InnerNodes: proof.InnerNodes,
+		Leaves:     proof.Leaves,
+		hasher:     proof.hasher,
+	}
+}
+
+func (proof *RangeProof) getHasher() Hasher {
+	if proof.hasher == nil {
+		return SHA256
+	}
+	return proof.hasher
 }
 
 // Keys returns all the keys in the RangeProof.  NOTE: The keys here may
@@ -106,7 +136,7 @@ func (proof *RangeProof) VerifyItem(key, value []byte) error {
 	if i >= len(leaves) || !bytes.Equal(leaves[i].Key, key) {
 		return errors.Wrap(ErrInvalidProof, "leaf key not found in proof")
 	}
-	valueHash := tmhash.Sum(value)
+	valueHash := hashBytes(proof.getHasher(), value)
 	if !bytes.Equal(leaves[i].ValueHash, valueHash) {
 		return errors.Wrap(ErrInvalidProof, "leaf value hash not same")
 	}
@@ -245,7 +275,7 @@ func (proof *RangeProof) _computeRootHash() (rootHash []byte, treeEnd bool, err
 		hash = (pathWithLeaf{
 			Path: path,
 			Leaf: nleaf,
-		}).computeRootHash()
+		}).computeRootHash(proof.getHasher())
 
 		// If we don't have any leaves left, we're done.
 		if len(leaves) == 0 {
@@ -321,7 +351,8 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 	if t.root == nil {
 		return nil, nil, nil, nil
 	}
-	t.root.hashWithCount() // Ensure that all hashes are calculated.
+	hasher := t.hasher()
+	t.root.hashWithCount(hasher) // Ensure that all hashes are calculated.
 
 	// Get the first key/value pair proof, which provides us with the left key.
 	path, left, err := t.root.PathToLeaf(t, keyStart)
@@ -341,7 +372,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 	var leaves = []proofLeafNode{
 		{
 			Key:       left.key,
-			ValueHash: tmhash.Sum(left.value),
+			ValueHash: hashBytes(hasher, left.value),
 			Version:   left.version,
 		},
 	}
@@ -358,6 +389,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 		return &RangeProof{
 			LeftPath: path,
 			Leaves:   leaves,
+			hasher:   hasher,
 		}, keys, values, nil
 	}
 
@@ -403,7 +435,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 				// Append leaf to leaves.
 				leaves = append(leaves, proofLeafNode{
 					Key:       node.key,
-					ValueHash: tmhash.Sum(node.value),
+					ValueHash: hashBytes(hasher, node.value),
 					Version:   node.version,
 				})
 				leafCount++
@@ -445,6 +477,7 @@ func (t *ImmutableTree) getRangeProof(keyStart, keyEnd []byte, limit int) (proof
 		LeftPath:   path,
 		InnerNodes: innersq,
 		Leaves:     leaves,
+		hasher:     hasher,
 	}, keys, values, nil
 }
 
@@ -0,0 +1,249 @@
+package iavl
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotManifest describes a chunked snapshot of a single tree version.
+// Chunk i contains the leaves with indexes [i*ChunkSize, (i+1)*ChunkSize).
+type SnapshotManifest struct {
+	Version   int64  `json:"version"`
+	Hash      []byte `json:"hash"`
+	ChunkSize int64  `json:"chunk_size"`
+	Chunks    int64  `json:"chunks"`
+}
+
+// SnapshotChunk is a contiguous range of leaves of a tree version, together
+// with the range proof that ties it to the version's root hash.
+type SnapshotChunk struct {
+	Index  int64       `json:"index"`
+	Keys   [][]byte    `json:"keys"`
+	Values [][]byte    `json:"values"`
+	Proof  *RangeProof `json:"proof"`
+}
+
+// SnapshotManifest returns the manifest for a chunked snapshot of the tree.
+func (t *ImmutableTree) SnapshotManifest(chunkSize int64) (*SnapshotManifest, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size must be greater than 0")
+	}
+	return &SnapshotManifest{
+		Version:   t.version,
+		Hash:      t.Hash(),
+		ChunkSize: chunkSize,
+		Chunks:    (t.Size() + chunkSize - 1) / chunkSize,
+	}, nil
+}
+
+// GetSnapshotChunk returns the chunk with the given index of a snapshot with
+// the given chunk size.
+func (t *ImmutableTree) GetSnapshotChunk(chunkSize, index int64) (*SnapshotChunk, error) {
+	if chunkSize <= 0 {
+		return nil, errors.New("chunk size must be greater than 0")
+	}
+	if index < 0 || index*chunkSize >= t.Size() {
+		return nil, errors.Errorf("chunk %d does not exist", index)
+	}
+	startKey, _ := t.GetByIndex(index * chunkSize)
+
+	// getRangeProof stops before the key that reaches the limit, so ask for
+	// one more leaf than we need. The extra leaf, if any, is left in the proof.
+	keys, values, proof, err := t.GetRangeWithProof(startKey, nil, int(chunkSize)+1)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing range proof")
+	}
+	return &SnapshotChunk{
+		Index:  index,
+		Keys:   keys,
+		Values: values,
+		Proof:  proof,
+	}, nil
+}
+
+// SnapshotRestorer rebuilds a tree version from snapshot chunks. Chunks can
+// be added in any order and each one is verified against the manifest's root
+// hash on its own. Verified nodes are kept in memory until Commit.
+type SnapshotRestorer struct {
+	tree     *MutableTree
+	manifest SnapshotManifest
+	received map[int64]bool
+	leaves   map[string]*Node  // leaf hash -> leaf node, without value
+	values   map[string][]byte // leaf hash -> value
+	inners   map[string]*Node  // inner hash -> inner node, without key
+}
+
+// RestoreSnapshot returns a SnapshotRestorer for the given manifest. The tree
+// must be empty.
+func (tree *MutableTree) RestoreSnapshot(manifest SnapshotManifest) (*SnapshotRestorer, error) {
+	if manifest.ChunkSize <= 0 {
+		return nil, errors.New("chunk size must be greater than 0")
+	}
+	if manifest.Chunks < 0 || (manifest.Chunks == 0) != (len(manifest.Hash) == 0) {
+		return nil, errors.Errorf("invalid chunk count %d for root hash %X", manifest.Chunks, manifest.Hash)
+	}
+	if len(tree.versions) > 0 || tree.ndb.getLatestVersion() > 0 {
+		return nil, errors.New("tree must be empty to restore a snapshot")
+	}
+	return &SnapshotRestorer{
+		tree:     tree,
+		manifest: manifest,
+		received: map[int64]bool{},
+		leaves:   map[string]*Node{},
+		values:   map[string][]byte{},
+		inners:   map[string]*Node{},
+	}, nil
+}
+
+// AddChunk verifies a chunk against the manifest and stores its nodes. A
+// chunk that fails verification is rejected without affecting the chunks
+// already added.
+func (r *SnapshotRestorer) AddChunk(chunk *SnapshotChunk) error {
+	if chunk == nil || chunk.Proof == nil {
+		return errors.New("chunk has no proof")
+	}
+	if chunk.Index < 0 || chunk.Index >= r.manifest.Chunks {
+		return errors.Errorf("chunk index %d out of range", chunk.Index)
+	}
+	if r.received[chunk.Index] {
+		return nil
+	}
+	if len(chunk.Keys) == 0 || len(chunk.Keys) != len(chunk.Values) {
+		return errors.Errorf("chunk %d has %d keys and %d values", chunk.Index, len(chunk.Keys), len(chunk.Values))
+	}
+
+	// Never trust memoized values from the sender.
+	proof := &RangeProof{
+		LeftPath:   chunk.Proof.LeftPath,
+		InnerNodes: chunk.Proof.InnerNodes,
+		Leaves:     chunk.Proof.Leaves,
+		hasher:     r.tree.ndb.hasher,
+	}
+	if err := proof.Verify(r.manifest.Hash); err != nil {
+		return errors.Wrapf(err, "verifying chunk %d", chunk.Index)
+	}
+	if len(proof.Leaves) < len(chunk.Keys) {
+		return errors.Wrapf(ErrInvalidProof, "chunk %d has more keys than proven leaves", chunk.Index)
+	}
+	for i, key := range chunk.Keys {
+		leaf := proof.Leaves[i]
+		if !bytes.Equal(leaf.Key, key) || !bytes.Equal(leaf.ValueHash, hashBytes(proof.hasher, chunk.Values[i])) {
+			return errors.Wrapf(ErrInvalidProof, "chunk %d item %d does not match proven leaf", chunk.Index, i)
+		}
+	}
+
+	// The proof establishes the position of the first leaf, and the leaves of
+	// a range proof are contiguous, so this pins down the exact chunk range.
+	if proof.LeftIndex() != chunk.Index*r.manifest.ChunkSize {
+		return errors.Wrapf(ErrInvalidProof, "chunk %d starts at leaf %d", chunk.Index, proof.LeftIndex())
+	}
+	size := int64(1)
+	if len(proof.LeftPath) > 0 {
+		size = proof.LeftPath[0].Size
+	}
+	expected := r.manifest.ChunkSize
+	if chunk.Index == r.manifest.Chunks-1 {
+		expected = size - chunk.Index*r.manifest.ChunkSize
+	}
+	if int64(len(chunk.Keys)) != expected {
+		return errors.Wrapf(ErrInvalidProof, "chunk %d has %d keys, expected %d", chunk.Index, len(chunk.Keys), expected)
+	}
+
+	r.collect(proof.LeftPath, proof.Leaves[0])
+	for i, path := range proof.InnerNodes {
+		r.collect(path, proof.Leaves[i+1])
+	}
+	for i, value := range chunk.Values {
+		r.values[string(proof.Leaves[i].hash(proof.hasher))] = value
+	}
+	r.received[chunk.Index] = true
+	return nil
+}
+
+// collect records the leaf and the inner nodes of a verified path.
+func (r *SnapshotRestorer) collect(path PathToLeaf, leaf proofLeafNode) {
+	hash := leaf.hash(r.tree.ndb.hasher)
+	r.leaves[string(hash)] = &Node{
+		key:     leaf.Key,
+		version: leaf.Version,
+		size:    1,
+		hash:    hash,
+	}
+	for i := len(path) - 1; i >= 0; i-- {
+		pin := path[i]
+		node := &Node{
+			height:    pin.Height,
+			size:      pin.Size,
+			version:   pin.Version,
+			leftHash:  pin.Left,
+			rightHash: pin.Right,
+		}
+		if len(pin.Left) == 0 {
+			node.leftHash = hash
+		} else {
+			node.rightHash = hash
+		}
+		hash = pin.hash(r.tree.ndb.hasher, hash)
+		node.hash = hash
+		r.inners[string(hash)] = node
+	}
+}
+
+// Done returns whether all chunks have been added.
+func (r *SnapshotRestorer) Done() bool {
+	return int64(len(r.received)) == r.manifest.Chunks
+}
+
+// Commit rebuilds the tree from the added chunks, checks its root hash and
+// saves it as the snapshot version. If it fails, the nodes written so far are
+// deleted.
+func (r *SnapshotRestorer) Commit() error {
+	if !r.Done() {
+		return errors.Errorf("only received %d of %d chunks", len(r.received), r.manifest.Chunks)
+	}
+	importer, err := r.tree.Import(r.manifest.Version, r.manifest.Hash)
+	if err != nil {
+		return err
+	}
+	if len(r.manifest.Hash) > 0 {
+		if _, err := r.export(importer, r.manifest.Hash); err != nil {
+			return importer.abort(err)
+		}
+	}
+	return importer.Commit()
+}
+
+// export adds the subtree with the given hash to the importer in post-order,
+// returning the smallest key in the subtree.
+func (r *SnapshotRestorer) export(importer *Importer, hash []byte) ([]byte, error) {
+	if leaf, ok := r.leaves[string(hash)]; ok {
+		value, ok := r.values[string(hash)]
+		if !ok {
+			return nil, errors.Errorf("missing value for key %X", leaf.key)
+		}
+		return leaf.key, importer.Add(&ExportNode{
+			Key:     leaf.key,
+			Value:   value,
+			Version: leaf.version,
+			Height:  0,
+		})
+	}
+	inner, ok := r.inners[string(hash)]
+	if !ok {
+		return nil, errors.Errorf("missing node %X", hash)
+	}
+	leftKey, err := r.export(importer, inner.leftHash)
+	if err != nil {
+		return nil, err
+	}
+	rightKey, err := r.export(importer, inner.rightHash)
+	if err != nil {
+		return nil, err
+	}
+	return leftKey, importer.Add(&ExportNode{
+		Key:     rightKey,
+		Version: inner.version,
+		Height:  inner.height,
+	})
+}
@@ -0,0 +1,141 @@
+package iavl
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	db "github.com/tendermint/tm-db"
+)
+
+func getSnapshotChunks(t *testing.T, itree *ImmutableTree, chunkSize int64) (*SnapshotManifest, []*SnapshotChunk) {
+	manifest, err := itree.SnapshotManifest(chunkSize)
+	require.NoError(t, err)
+	chunks := make([]*SnapshotChunk, manifest.Chunks)
+	for i := range chunks {
+		chunks[i], err = itree.GetSnapshotChunk(chunkSize, int64(i))
+		require.NoError(t, err)
+	}
+	return manifest, chunks
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+
+	for _, chunkSize := range []int64{1, 7, 100, itree.Size(), itree.Size() + 1} {
+		manifest, chunks := getSnapshotChunks(t, itree, chunkSize)
+
+		tree := NewMutableTree(db.NewMemDB(), 0)
+		restorer, err := tree.RestoreSnapshot(*manifest)
+		require.NoError(t, err)
+		for _, i := range rand.Perm(len(chunks)) {
+			require.False(t, restorer.Done())
+			require.NoError(t, restorer.AddChunk(chunks[i]))
+		}
+		require.True(t, restorer.Done())
+		require.NoError(t, restorer.Commit())
+
+		require.Equal(t, itree.Version(), tree.Version())
+		require.Equal(t, itree.Hash(), tree.Hash())
+		require.Equal(t, itree.Size(), tree.Size())
+	}
+}
+
+func TestSnapshotRestoreSingleKey(t *testing.T) {
+	source := NewMutableTree(db.NewMemDB(), 0)
+	source.Set([]byte("a"), []byte{})
+	_, _, err := source.SaveVersion()
+	require.NoError(t, err)
+
+	manifest, chunks := getSnapshotChunks(t, source.ImmutableTree, 10)
+	require.Len(t, chunks, 1)
+
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	restorer, err := tree.RestoreSnapshot(*manifest)
+	require.NoError(t, err)
+	require.NoError(t, restorer.AddChunk(chunks[0]))
+	require.NoError(t, restorer.Commit())
+	require.Equal(t, source.Hash(), tree.Hash())
+}
+
+func TestSnapshotRejectsCorruptChunk(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	manifest, chunks := getSnapshotChunks(t, itree, 50)
+	require.True(t, len(chunks) > 2)
+
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	restorer, err := tree.RestoreSnapshot(*manifest)
+	require.NoError(t, err)
+
+	// Changed value.
+	chunk := *chunks[1]
+	chunk.Values = append([][]byte{}, chunk.Values...)
+	chunk.Values[3] = []byte("corrupt")
+	require.Error(t, restorer.AddChunk(&chunk))
+
+	// Chunk served under the wrong index.
+	chunk = *chunks[1]
+	chunk.Index = 2
+	require.Error(t, restorer.AddChunk(&chunk))
+
+	// Truncated chunk.
+	chunk = *chunks[1]
+	chunk.Keys, chunk.Values = chunk.Keys[:10], chunk.Values[:10]
+	require.Error(t, restorer.AddChunk(&chunk))
+
+	// Forged memoized root hash.
+	chunk = *chunks[1]
+	proof := *chunk.Proof
+	proof.Leaves = append([]proofLeafNode{}, proof.Leaves...)
+	proof.Leaves[0].Version++
+	proof.RootHash = manifest.Hash
+	proof.RootVerified = true
+	chunk.Proof = &proof
+	require.Error(t, restorer.AddChunk(&chunk))
+
+	// The valid chunks are still accepted.
+	for _, chunk := range chunks {
+		require.NoError(t, restorer.AddChunk(chunk))
+	}
+	require.NoError(t, restorer.Commit())
+	require.Equal(t, itree.Hash(), tree.Hash())
+}
+
+func TestSnapshotCommitIncomplete(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	manifest, chunks := getSnapshotChunks(t, itree, 50)
+
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	restorer, err := tree.RestoreSnapshot(*manifest)
+	require.NoError(t, err)
+	require.NoError(t, restorer.AddChunk(chunks[0]))
+	require.Error(t, restorer.Commit())
+	require.Equal(t, int64(0), tree.Version())
+}
+
+func TestSnapshotCommitMissingValue(t *testing.T) {
+	itree := setupExportTreeRandom(t)
+	manifest, chunks := getSnapshotChunks(t, itree, 50)
+
+	tree := NewMutableTree(db.NewMemDB(), 0)
+	restorer, err := tree.RestoreSnapshot(*manifest)
+	require.NoError(t, err)
+	for _, chunk := range chunks {
+		require.NoError(t, restorer.AddChunk(chunk))
+	}
+	// Drop the value of the last leaf, which is exported after all others.
+	var last *Node
+	for _, leaf := range restorer.leaves {
+		if last == nil || string(leaf.key) > string(last.key) {
+			last = leaf
+		}
+	}
+	delete(restorer.values, string(last.hash))
+
+	require.Error(t, restorer.Commit())
+	require.Equal(t, int64(0), tree.Version())
+	tree.ndb.Commit()
+	require.Empty(t, tree.ndb.nodes())
+	require.Empty(t, tree.ndb.roots())
+	require.Empty(t, tree.ndb.getPending())
+}
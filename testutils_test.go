@@ -58,7 +58,7 @@ func T(n *Node) *MutableTree {
 	d := db.NewDB("test", db.MemDBBackend, "")
 	t := NewMutableTree(d, 0)
 
-	n.hashWithCount()
+	n.hashWithCount(SHA256)
 	t.root = n
 	return t
 }
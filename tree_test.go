@@ -130,7 +130,7 @@ func TestVersionedRandomTreeSmallKeys(t *testing.T) {
 
 	// Try getting random keys.
 	for i := 0; i < keysPerVersion; i++ {
-		_, val := tree.Get([]byte(cmn.RandStr(1)))
+		val := tree.Get([]byte(cmn.RandStr(1)))
 		require.NotNil(val)
 		require.NotEmpty(val)
 	}
@@ -171,7 +171,7 @@ func TestVersionedRandomTreeSmallKeysRandomDeletes(t *testing.T) {
 
 	// Try getting random keys.
 	for i := 0; i < keysPerVersion; i++ {
-		_, val := tree.Get([]byte(cmn.RandStr(1)))
+		val := tree.Get([]byte(cmn.RandStr(1)))
 		require.NotNil(val)
 		require.NotEmpty(val)
 	}
@@ -389,7 +389,7 @@ func TestVersionedTree(t *testing.T) {
 	_, val = tree.GetVersioned([]byte("key2"), 2)
 	require.Equal("val1", string(val))
 
-	_, val = tree.Get([]byte("key2"))
+	val = tree.Get([]byte("key2"))
 	require.Equal("val2", string(val))
 
 	// "key1"
@@ -405,7 +405,7 @@ func TestVersionedTree(t *testing.T) {
 	_, val = tree.GetVersioned([]byte("key1"), 4)
 	require.Nil(val)
 
-	_, val = tree.Get([]byte("key1"))
+	val = tree.Get([]byte("key1"))
 	require.Equal("val0", string(val))
 
 	// "key3"
@@ -442,10 +442,10 @@ func TestVersionedTree(t *testing.T) {
 
 	// But they should still exist in the latest version.
 
-	_, val = tree.Get([]byte("key2"))
+	val = tree.Get([]byte("key2"))
 	require.Equal("val2", string(val))
 
-	_, val = tree.Get([]byte("key3"))
+	val = tree.Get([]byte("key3"))
 	require.Equal("val1", string(val))
 
 	// Version 1 should still be available.
@@ -522,16 +522,16 @@ func TestVersionedTreeOrphanDeleting(t *testing.T) {
 
 	tree.DeleteVersion(2)
 
-	_, val := tree.Get([]byte("key0"))
+	val := tree.Get([]byte("key0"))
 	require.Equal(t, val, []byte("val2"))
 
-	_, val = tree.Get([]byte("key1"))
+	val = tree.Get([]byte("key1"))
 	require.Nil(t, val)
 
-	_, val = tree.Get([]byte("key2"))
+	val = tree.Get([]byte("key2"))
 	require.Equal(t, val, []byte("val2"))
 
-	_, val = tree.Get([]byte("key3"))
+	val = tree.Get([]byte("key3"))
 	require.Equal(t, val, []byte("val1"))
 
 	tree.DeleteVersion(1)
@@ -725,7 +725,7 @@ func TestVersionedCheckpoints(t *testing.T) {
 	// Make sure all keys exist at least once.
 	for _, ks := range keys {
 		for _, k := range ks {
-			_, val := tree.Get(k)
+			val := tree.Get(k)
 			require.NotEmpty(val)
 		}
 	}
@@ -1107,12 +1107,12 @@ func TestCopyValueSemantics(t *testing.T) {
 	val := []byte("v1")
 
 	tree.Set([]byte("k"), val)
-	_, v := tree.Get([]byte("k"))
+	v := tree.Get([]byte("k"))
 	require.Equal([]byte("v1"), v)
 
 	val[1] = '2'
 
-	_, val = tree.Get([]byte("k"))
+	val = tree.Get([]byte("k"))
 	require.Equal([]byte("v2"), val)
 }
 
@@ -1135,13 +1135,13 @@ func TestRollback(t *testing.T) {
 
 	require.Equal(int64(2), tree.Size())
 
-	_, val := tree.Get([]byte("r"))
+	val := tree.Get([]byte("r"))
 	require.Nil(val)
 
-	_, val = tree.Get([]byte("s"))
+	val = tree.Get([]byte("s"))
 	require.Nil(val)
 
-	_, val = tree.Get([]byte("t"))
+	val = tree.Get([]byte("t"))
 	require.Equal([]byte("v"), val)
 }
 
@@ -1166,7 +1166,7 @@ func TestLazyLoadVersion(t *testing.T) {
 	require.NoError(t, err, "unexpected error when lazy loading version")
 	require.Equal(t, version, int64(maxVersions))
 
-	_, value := tree.Get([]byte(fmt.Sprintf("key_%d", maxVersions)))
+	value := tree.Get([]byte(fmt.Sprintf("key_%d", maxVersions)))
 	require.Equal(t, value, []byte(fmt.Sprintf("value_%d", maxVersions)), "unexpected value")
 
 	// require the ability to lazy load an older version
@@ -1174,7 +1174,7 @@ func TestLazyLoadVersion(t *testing.T) {
 	require.NoError(t, err, "unexpected error when lazy loading version")
 	require.Equal(t, version, int64(maxVersions-1))
 
-	_, value = tree.Get([]byte(fmt.Sprintf("key_%d", maxVersions-1)))
+	value = tree.Get([]byte(fmt.Sprintf("key_%d", maxVersions-1)))
 	require.Equal(t, value, []byte(fmt.Sprintf("value_%d", maxVersions-1)), "unexpected value")
 
 	// require the inability to lazy load a non-valid version
@@ -0,0 +1,209 @@
+package iavl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	dbm "github.com/tendermint/tm-db"
+)
+
+// ProblemKind is the kind of a problem found by Verify.
+type ProblemKind int
+
+const (
+	// ProblemMissingNode is a node that is referenced by a root or an inner
+	// node, but does not exist.
+	ProblemMissingNode ProblemKind = iota + 1
+	// ProblemCorruptNode is a node that can't be decoded, or whose hash does
+	// not match its key.
+	ProblemCorruptNode
+	// ProblemUnreachableNode is a node that is not reachable from any root.
+	ProblemUnreachableNode
+	// ProblemInvalidOrphan is an orphan entry whose version range does not
+	// match its node.
+	ProblemInvalidOrphan
+)
+
+// String implements fmt.Stringer.
+func (k ProblemKind) String() string {
+	switch k {
+	case ProblemMissingNode:
+		return "missing node"
+	case ProblemCorruptNode:
+		return "corrupt node"
+	case ProblemUnreachableNode:
+		return "unreachable node"
+	case ProblemInvalidOrphan:
+		return "invalid orphan"
+	default:
+		return "unknown"
+	}
+}
+
+// Problem is a problem found by Verify, with the hash of the node it concerns.
+// For missing and corrupt nodes, Version is the latest version the node is
+// reachable from. For invalid orphans, it is the last version of the
+// orphan's range.
+type Problem struct {
+	Kind    ProblemKind
+	Hash    []byte
+	Version int64
+	Detail  string
+}
+
+// String implements fmt.Stringer.
+func (p Problem) String() string {
+	return fmt.Sprintf("%v %X (version %d): %s", p.Kind, p.Hash, p.Version, p.Detail)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Versions int       // Number of versions checked.
+	Nodes    int       // Number of nodes reachable from the versions.
+	Orphans  int       // Number of orphan entries checked.
+	Problems []Problem // Problems found, ordered by kind.
+}
+
+// OK returns whether no problems were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks the integrity of the tree stored in db, without loading it.
+// It walks every saved version, checks that every reachable node exists and
+// hashes to its key, that every stored node is reachable from a version, and
+// that every orphan entry refers to an existing node whose version range
+// covers all the versions it is reachable from. It reports problems instead
+// of panicking, so that it can be used on damaged databases. Nodes are
+// hashed with the hasher recorded in the database, which must be registered
+// with RegisterHasher unless it is built in.
+func Verify(db dbm.DB) (*VerifyReport, error) {
+	ndb := newNodeDB(db, newDefaultCache(0))
+	meta, ok, err := ndb.readMetadata()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		if err := meta.check(); err != nil {
+			return nil, err
+		}
+		hasher, known := GetHasher(meta.Hasher)
+		if !known {
+			return nil, errors.Wrapf(ErrIncompatibleTree, "tree was written with unknown hasher %q", meta.Hasher)
+		}
+		ndb.hasher = hasher
+	}
+	report := &VerifyReport{}
+	var missing, corrupt, unreachable, invalidOrphans []Problem
+
+	roots, _ := ndb.getRoots()
+	versions := make([]int64, 0, len(roots))
+	for version := range roots {
+		versions = append(versions, version)
+	}
+	report.Versions = len(versions)
+
+	// Walk the versions from the latest, so that the first visit of a node
+	// finds the latest version it is reachable from. Subtrees that were
+	// already visited have been visited from a later version, and are skipped.
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	latestVersions := map[string]int64{}
+	nodes := map[string]*Node{}
+	for _, version := range versions {
+		stack := [][]byte{}
+		if len(roots[version]) > 0 {
+			stack = append(stack, roots[version])
+		}
+		for len(stack) > 0 {
+			hash := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if _, ok := latestVersions[string(hash)]; ok {
+				continue
+			}
+			latestVersions[string(hash)] = version
+
+			node, problem := ndb.verifyNode(hash)
+			if node == nil {
+				problem.Version = version
+				if problem.Kind == ProblemMissingNode {
+					missing = append(missing, problem)
+				} else {
+					corrupt = append(corrupt, problem)
+				}
+				continue
+			}
+			nodes[string(hash)] = node
+			if !node.isLeaf() {
+				stack = append(stack, node.rightHash, node.leftHash)
+			}
+		}
+	}
+	report.Nodes = len(latestVersions)
+
+	ndb.traversePrefix(nodeKeyFormat.Key(), func(key, value []byte) {
+		var hash []byte
+		nodeKeyFormat.Scan(key, &hash)
+		if _, ok := latestVersions[string(hash)]; !ok {
+			unreachable = append(unreachable, Problem{
+				Kind:   ProblemUnreachableNode,
+				Hash:   hash,
+				Detail: "not reachable from any version",
+			})
+		}
+	})
+
+	ndb.traverseOrphans(func(key, hash []byte) {
+		var fromVersion, toVersion int64
+		orphanKeyFormat.Scan(key, &toVersion, &fromVersion)
+		report.Orphans++
+
+		problem := Problem{Kind: ProblemInvalidOrphan, Hash: hash, Version: toVersion}
+		node, ok := nodes[string(hash)]
+		latest, reachable := latestVersions[string(hash)]
+		var nodeProblem Problem
+		if !reachable {
+			node, nodeProblem = ndb.verifyNode(hash)
+			ok = node != nil
+		}
+		switch {
+		case fromVersion > toVersion:
+			problem.Detail = fmt.Sprintf("first version %d is after last version %d", fromVersion, toVersion)
+		case nodeProblem.Kind == ProblemMissingNode:
+			problem.Detail = nodeProblem.Detail
+		case ok && node.version != fromVersion:
+			problem.Detail = fmt.Sprintf("first version %d does not match node version %d", fromVersion, node.version)
+		case reachable && latest > toVersion:
+			problem.Detail = fmt.Sprintf("node is reachable from version %d, after the last version", latest)
+		default:
+			return
+		}
+		invalidOrphans = append(invalidOrphans, problem)
+	})
+
+	for _, problems := range [][]Problem{missing, corrupt, unreachable, invalidOrphans} {
+		report.Problems = append(report.Problems, problems...)
+	}
+	return report, nil
+}
+
+// verifyNode decodes the node stored under hash, and checks that it hashes to
+// it. If not, it returns a nil node and the problem.
+func (ndb *nodeDB) verifyNode(hash []byte) (*Node, Problem) {
+	if len(hash) != hashSize {
+		return nil, Problem{Kind: ProblemMissingNode, Hash: hash, Detail: "invalid hash length"}
+	}
+	buf := ndb.db.Get(ndb.nodeKey(hash))
+	if buf == nil {
+		return nil, Problem{Kind: ProblemMissingNode, Hash: hash, Detail: "node does not exist"}
+	}
+	node, err := MakeNode(buf)
+	if err != nil {
+		return nil, Problem{Kind: ProblemCorruptNode, Hash: hash, Detail: err.Error()}
+	}
+	if actual := node._hash(ndb.hasher); !bytes.Equal(actual, hash) {
+		return nil, Problem{Kind: ProblemCorruptNode, Hash: hash, Detail: fmt.Sprintf("node hashes to %X", actual)}
+	}
+	return node, Problem{}
+}
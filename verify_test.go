@@ -0,0 +1,98 @@
+package iavl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	cmn "github.com/tendermint/iavl/common"
+	db "github.com/tendermint/tm-db"
+)
+
+func newVerifyTestTree(t *testing.T) (*MutableTree, db.DB) {
+	memDB := db.NewMemDB()
+	tree := NewMutableTreeWithOpts(memDB, 0, &Options{Pruning: NewPruningOptions(3, 0, 0)})
+	for v := 0; v < 10; v++ {
+		for i := 0; i < 20; i++ {
+			tree.Set([]byte(cmn.RandStr(2)), []byte(cmn.RandStr(4)))
+		}
+		tree.Remove([]byte(cmn.RandStr(2)))
+		_, _, err := tree.SaveVersion()
+		require.NoError(t, err)
+	}
+	return tree, memDB
+}
+
+func encodeNode(t *testing.T, node *Node) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, node.writeBytes(&buf))
+	return buf.Bytes()
+}
+
+func mustVerify(t *testing.T, db db.DB) *VerifyReport {
+	report, err := Verify(db)
+	require.NoError(t, err)
+	return report
+}
+
+func problemKinds(report *VerifyReport) []ProblemKind {
+	kinds := []ProblemKind{}
+	for _, problem := range report.Problems {
+		kinds = append(kinds, problem.Kind)
+	}
+	return kinds
+}
+
+func TestVerify(t *testing.T) {
+	tree, memDB := newVerifyTestTree(t)
+	require.NoError(t, tree.DeleteVersion(8))
+
+	report := mustVerify(t, memDB)
+	require.True(t, report.OK(), "%v", report.Problems)
+	require.Equal(t, 2, report.Versions)
+	require.Equal(t, len(tree.ndb.nodes()), report.Nodes)
+	require.Equal(t, len(tree.ndb.orphans()), report.Orphans)
+	require.NotZero(t, report.Orphans)
+}
+
+func TestVerifyDamaged(t *testing.T) {
+	tree, memDB := newVerifyTestTree(t)
+	root := tree.root
+
+	// A missing node is reported with the version it is reachable from, and
+	// its subtree becomes unreachable.
+	memDB.Delete(tree.ndb.nodeKey(root.leftHash))
+	report := mustVerify(t, memDB)
+	require.False(t, report.OK())
+	require.Equal(t, ProblemMissingNode, report.Problems[0].Kind)
+	require.Equal(t, root.leftHash, report.Problems[0].Hash)
+	require.EqualValues(t, 10, report.Problems[0].Version)
+
+	// A node that doesn't hash to its key is corrupt.
+	tree, memDB = newVerifyTestTree(t)
+	first, last := tree.root, tree.root
+	for !first.isLeaf() {
+		first = first.getLeftNode(tree.ImmutableTree)
+	}
+	for !last.isLeaf() {
+		last = last.getRightNode(tree.ImmutableTree)
+	}
+	corrupted := NewNode(first.key, []byte("corrupted"), first.version)
+	memDB.Set(tree.ndb.nodeKey(first.hash), encodeNode(t, corrupted))
+	memDB.Set(tree.ndb.nodeKey(last.hash), []byte{0xff})
+	require.Equal(t, []ProblemKind{ProblemCorruptNode, ProblemCorruptNode}, problemKinds(mustVerify(t, memDB)))
+
+	// Nodes that are not reachable from any version are reported, and orphan
+	// entries must cover the versions their node is reachable from.
+	tree, memDB = newVerifyTestTree(t)
+	stray := NewNode([]byte("stray"), []byte("value"), 3)
+	stray._hash(SHA256)
+	memDB.Set(tree.ndb.nodeKey(stray.hash), encodeNode(t, stray))
+	memDB.Set(tree.ndb.orphanKey(tree.root.version, 9, tree.root.hash), tree.root.hash)
+	memDB.Set(tree.ndb.orphanKey(5, 4, stray.hash), stray.hash)
+	memDB.Set(tree.ndb.orphanKey(4, 8, stray.hash), stray.hash)
+	report = mustVerify(t, memDB)
+	require.Equal(t, []ProblemKind{ProblemUnreachableNode, ProblemInvalidOrphan, ProblemInvalidOrphan, ProblemInvalidOrphan},
+		problemKinds(report))
+	require.Equal(t, stray.hash, report.Problems[0].Hash)
+}
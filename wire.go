@@ -2,17 +2,29 @@ package iavl
 
 import (
 	amino "github.com/tendermint/go-amino"
+	"github.com/tendermint/tendermint/crypto/merkle"
 )
 
-var cdc *amino.Codec
+var cdc = amino.NewCodec()
 
 func init() {
 	// NOTE: It's important that there be no conflicts here,
 	// as that would change the canonical representations.
-	//cdc = amino.NewCodec()
-	//RegisterWire(cdc)
+	RegisterWire(cdc)
 }
 
+// RegisterWire registers the types of the package that amino needs to know
+// about. The proof operators only contain concrete types, so there are none.
 func RegisterWire(cdc *amino.Codec) {
-	// TODO
+}
+
+// DefaultProofRuntime returns a ProofRuntime that decodes the IAVL value and
+// absence operators, as well as merkle.SimpleValueOp, so that proofs of a
+// key in an IAVL tree under a simple Merkle root can be verified.
+func DefaultProofRuntime() *merkle.ProofRuntime {
+	prt := merkle.NewProofRuntime()
+	prt.RegisterOpDecoder(merkle.ProofOpSimpleValue, merkle.SimpleValueOpDecoder)
+	prt.RegisterOpDecoder(ProofOpIAVLValue, IAVLValueOpDecoder)
+	prt.RegisterOpDecoder(ProofOpIAVLAbsence, IAVLAbsenceOpDecoder)
+	return prt
 }